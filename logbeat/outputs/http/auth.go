@@ -0,0 +1,149 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	fileCheckInterval   = 1 * time.Second
+	oauth2RefreshBefore = 1 * time.Minute
+)
+
+type oauth2Config struct {
+	ClientId       string            `config:"client_id"`
+	ClientSecret   string            `config:"client_secret"`
+	TokenURL       string            `config:"token_url"`
+	Scopes         []string          `config:"scopes"`
+	EndpointParams map[string]string `config:"endpoint_params"`
+	RefreshBefore  time.Duration     `config:"refresh_before"`
+}
+
+// tokenSource returns the bearer token put in the Authorization header.
+type tokenSource interface {
+	Token() (string, error)
+}
+
+func (c *oauth2Config) Validate() error {
+	if c.ClientId == "" || c.TokenURL == "" {
+		return errors.New("oauth2.client_id and oauth2.token_url are required")
+	}
+	return nil
+}
+
+// newTokenSource returns nil when no token auth is configured. The oauth2
+// token is requested with the output's http client.
+func newTokenSource(s *clientSettings, httpClient *http.Client) tokenSource {
+	switch {
+	case s.OAuth2 != nil:
+		conf := clientcredentials.Config{
+			ClientID:       s.OAuth2.ClientId,
+			ClientSecret:   s.OAuth2.ClientSecret,
+			TokenURL:       s.OAuth2.TokenURL,
+			Scopes:         s.OAuth2.Scopes,
+			EndpointParams: make(map[string][]string, len(s.OAuth2.EndpointParams)),
+		}
+		for key, val := range s.OAuth2.EndpointParams {
+			conf.EndpointParams.Set(key, val)
+		}
+		refreshBefore := s.OAuth2.RefreshBefore
+		if refreshBefore <= 0 {
+			refreshBefore = oauth2RefreshBefore
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+		return &oauth2TokenSource{
+			src: oauth2.ReuseTokenSourceWithExpiry(nil, conf.TokenSource(ctx), refreshBefore),
+		}
+	case s.BearerTokenFile != "":
+		return &fileTokenSource{file: newFileValue(s.BearerTokenFile)}
+	case s.BearerToken != "":
+		return staticTokenSource(s.BearerToken)
+	}
+	return nil
+}
+
+// oauth2TokenSource caches the client credentials token, and requests a new
+// one refresh_before the token expires.
+type oauth2TokenSource struct {
+	src oauth2.TokenSource
+}
+
+func (s *oauth2TokenSource) Token() (string, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
+
+// fileTokenSource reads the token from a file which is rotated by others,
+// e.g. the projected service account token of kubernetes.
+type fileTokenSource struct {
+	file *fileValue
+}
+
+func (s *fileTokenSource) Token() (string, error) {
+	return s.file.Get()
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+// fileValue is the trimmed content of a file, which is read again when the
+// file is changed. The file is checked at most once per fileCheckInterval.
+type fileValue struct {
+	path string
+
+	mu        sync.Mutex
+	value     string
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func newFileValue(path string) *fileValue {
+	return &fileValue{path: path}
+}
+
+func (f *fileValue) Get() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if !f.checkedAt.IsZero() && now.Sub(f.checkedAt) < fileCheckInterval {
+		return f.value, nil
+	}
+	f.checkedAt = now
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if f.value != "" {
+			// keep the last value while the file is being replaced
+			return f.value, nil
+		}
+		return "", err
+	}
+	if f.value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.value = strings.TrimSpace(string(content))
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.value, nil
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// headerServer records the header of the requests it receives.
+type headerServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	values []string
+}
+
+func newHeaderServer(header string) *headerServer {
+	s := &headerServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.values = append(s.values, r.Header.Get(header))
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+func (s *headerServer) received() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.values, ",")
+}
+
+// writeFile writes a secret file, expire makes the next Get read it again.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expire(files ...*fileValue) {
+	for _, f := range files {
+		f.mu.Lock()
+		f.checkedAt = time.Time{}
+		f.mu.Unlock()
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	tests := []struct {
+		name          string
+		expiresIn     int
+		refreshBefore string
+		expected      string
+	}{
+		{"cached", 3600, "", "Bearer tok-1,Bearer tok-1"},
+		// the token is requested again refresh_before it expires
+		{"expiring", 30, "", "Bearer tok-1,Bearer tok-2"},
+		{"refresh_before", 30, "10s", "Bearer tok-1,Bearer tok-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id, secret, _ := r.BasicAuth()
+				if id != "id" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "logs" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"bearer","expires_in":%d}`, requests.Add(1), test.expiresIn)
+			}))
+			defer tokens.Close()
+			srv := newHeaderServer("Authorization")
+			defer srv.Close()
+
+			settings := map[string]any{
+				"hosts":                []string{strings.TrimPrefix(srv.URL, "http://")},
+				"channel":              "openobserve",
+				"oauth2.token_url":     tokens.URL,
+				"oauth2.client_id":     "id",
+				"oauth2.client_secret": "secret",
+				"oauth2.scopes":        []string{"logs"},
+			}
+			if test.refreshBefore != "" {
+				settings["oauth2.refresh_before"] = test.refreshBefore
+			}
+			output := newTestOutput(t, settings)
+			if err := sendTestEvents(output, 2, 1); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.received(); got != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestBearerTokenFile(t *testing.T) {
+	srv := newHeaderServer("Authorization")
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "token")
+	writeFile(t, path, "first\n")
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":             []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":           "openobserve",
+		"bearer_token_file": path,
+	})
+	if err := sendTestEvents(output, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rotated token is read again
+	writeFile(t, path, "second")
+	expire(output.(*client).conn.token.(*fileTokenSource).file)
+	if err := sendTestEvents(output, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.received(); got != "Bearer first,Bearer second" {
+		t.Fatalf("unexpected tokens %s", got)
+	}
+}
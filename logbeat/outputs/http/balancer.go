@@ -0,0 +1,506 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/common/backoff"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+type balanceConfig struct {
+	BackupHosts   []string          `config:"backup_hosts"`
+	HostWeights   []hostWeight      `config:"host_weights"`
+	MaxFailures   int               `config:"max_failures" validate:"min=0"`
+	EjectDuration time.Duration     `config:"eject_duration"`
+	HealthCheck   healthCheckConfig `config:"health_check"`
+	Discovery     discoveryConfig   `config:"discovery"`
+}
+
+type hostWeight struct {
+	Host   string `config:"host" validate:"required"`
+	Weight int    `config:"weight" validate:"min=1"`
+}
+
+type healthCheckConfig struct {
+	Enabled  bool          `config:"enabled"`
+	Path     string        `config:"path"`
+	Interval time.Duration `config:"interval"`
+	Timeout  time.Duration `config:"timeout"`
+}
+
+var defaultBalanceConfig = balanceConfig{
+	EjectDuration: 30 * time.Second,
+	HealthCheck: healthCheckConfig{
+		Path:     "/",
+		Interval: 10 * time.Second,
+		Timeout:  5 * time.Second,
+	},
+	Discovery: defaultDiscoveryConfig,
+}
+
+func (c *balanceConfig) Validate() error {
+	if c.EjectDuration <= 0 {
+		return fmt.Errorf("eject_duration must be positive, got %v", c.EjectDuration)
+	}
+	return nil
+}
+
+func (c *healthCheckConfig) Validate() error {
+	if c.Interval <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("health_check.interval and timeout must be positive, got %v and %v", c.Interval, c.Timeout)
+	}
+	return nil
+}
+
+// enabled reports whether the hosts are balanced by health instead of the
+// load balancer of libbeat.
+func (c *balanceConfig) enabled() bool {
+	return len(c.BackupHosts) > 0 || len(c.HostWeights) > 0 || c.MaxFailures > 0 || c.HealthCheck.Enabled ||
+		c.Discovery.enabled()
+}
+
+func (c *balanceConfig) weight(host string) int {
+	for _, w := range c.HostWeights {
+		if w.Host == host {
+			return w.Weight
+		}
+	}
+	return 1
+}
+
+// hostEndpoint is the state of a host shared by all workers.
+type hostEndpoint struct {
+	host      string
+	healthURL string
+	weight    int
+	backup    bool
+	// discovered hosts are replaced on every resolution, removed hosts are
+	// no longer picked and their clients are closed by the workers.
+	discovered bool
+	removed    bool
+
+	healthy      bool
+	failures     int
+	ejectedUntil time.Time
+	current      int
+	// breaker is the circuit breaker of the host, shared with its clients
+	breaker *circuitBreaker
+}
+
+func (ep *hostEndpoint) available(now time.Time) bool {
+	return ep.healthy && !now.Before(ep.ejectedUntil)
+}
+
+// hostPool picks the host of each batch: the healthy primary hosts by weight,
+// then the healthy backup hosts, and all hosts if none is healthy. The hosts
+// whose circuit breaker is open are skipped.
+type hostPool struct {
+	conf     *balanceConfig
+	log      *logp.Logger
+	channel  string
+	breakers *circuitBreakers
+
+	mu        sync.Mutex
+	endpoints []*hostEndpoint
+
+	checker *http.Client
+	done    chan struct{}
+	// refs is the number of workers using the pool, it is closed with the
+	// last one
+	refs      int
+	closeOnce sync.Once
+}
+
+func newHostPool(
+	conf *balanceConfig,
+	protocol, channel string,
+	hosts []string,
+	breakers *circuitBreakers,
+	log *logp.Logger,
+) (*hostPool, error) {
+	p := &hostPool{
+		conf:     conf,
+		log:      log,
+		channel:  channel,
+		breakers: breakers,
+		done:     make(chan struct{}),
+	}
+
+	for _, host := range hosts {
+		if err := p.add(protocol, host, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, host := range conf.BackupHosts {
+		if err := p.add(protocol, host, true); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *hostPool) add(protocol, host string, backup bool) error {
+	ep, err := p.newEndpoint(protocol, host, backup)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints = append(p.endpoints, ep)
+	return nil
+}
+
+func (p *hostPool) newEndpoint(protocol, host string, backup bool) (*hostEndpoint, error) {
+	healthURL, err := common.MakeURL(protocol, "/", host+p.conf.HealthCheck.Path, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host %s: %w", host, err)
+	}
+	return &hostEndpoint{
+		host:      host,
+		healthURL: healthURL,
+		weight:    p.conf.weight(host),
+		backup:    backup,
+		healthy:   true,
+		// the breakers are shared by host, whatever the path of the url
+		breaker: p.breakers.host(p.channel, healthURL),
+	}, nil
+}
+
+// sync replaces the discovered hosts with hosts, keeping the state of the
+// hosts still present and updating their weight and priority. The configured
+// backup hosts are left untouched.
+func (p *hostPool) sync(protocol string, hosts []discoveredHost) {
+	wanted := make(map[string]discoveredHost, len(hosts))
+	for _, h := range hosts {
+		wanted[h.host] = h
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	endpoints := p.endpoints[:0:0]
+	for _, ep := range p.endpoints {
+		if !ep.discovered {
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		if h, ok := wanted[ep.host]; ok {
+			delete(wanted, ep.host)
+			p.setDiscovered(ep, h)
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		ep.removed = true
+		p.log.Infof("host %s removed", ep.host)
+	}
+
+	for _, h := range hosts {
+		if _, ok := wanted[h.host]; !ok {
+			continue
+		}
+		ep, err := p.newEndpoint(protocol, h.host, h.backup)
+		if err != nil {
+			p.log.Errorf("discovered %v", err)
+			continue
+		}
+		ep.discovered = true
+		p.setDiscovered(ep, h)
+		endpoints = append(endpoints, ep)
+		p.log.Infof("host %s added", ep.host)
+	}
+	p.endpoints = endpoints
+}
+
+func (p *hostPool) setDiscovered(ep *hostEndpoint, h discoveredHost) {
+	ep.backup = h.backup
+	ep.weight = p.conf.weight(h.host)
+	if h.weight > 0 {
+		ep.weight = h.weight
+	}
+}
+
+func (p *hostPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.endpoints)
+}
+
+func (p *hostPool) isRemoved(ep *hostEndpoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ep.removed
+}
+
+// pick selects a host by smooth weighted round robin, among the hosts whose
+// circuit breaker isn't open. It returns nil when the pool has no host or the
+// breakers of all hosts are open.
+func (p *hostPool) pick(now time.Time) *hostEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := p.candidates(func(ep *hostEndpoint) bool { return !ep.backup && ep.available(now) })
+	if len(candidates) == 0 {
+		candidates = p.candidates(func(ep *hostEndpoint) bool { return ep.backup && ep.available(now) })
+	}
+	if len(candidates) == 0 {
+		candidates = p.candidates(func(ep *hostEndpoint) bool { return true })
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var best *hostEndpoint
+	total := 0
+	for _, ep := range candidates {
+		ep.current += ep.weight
+		total += ep.weight
+		if best == nil || ep.current > best.current {
+			best = ep
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (p *hostPool) candidates(filter func(*hostEndpoint) bool) []*hostEndpoint {
+	var ret []*hostEndpoint
+	for _, ep := range p.endpoints {
+		if filter(ep) && ep.breaker.ready() {
+			ret = append(ret, ep)
+		}
+	}
+	return ret
+}
+
+// report ejects a host for eject_duration after max_failures consecutive failures.
+func (p *hostPool) report(ep *hostEndpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		ep.failures = 0
+		return
+	}
+
+	ep.failures++
+	if p.conf.MaxFailures > 0 && ep.failures >= p.conf.MaxFailures {
+		ep.failures = 0
+		ep.ejectedUntil = time.Now().Add(p.conf.EjectDuration)
+		p.log.Warnf("host %s ejected for %v after %d consecutive failures", ep.host, p.conf.EjectDuration, p.conf.MaxFailures)
+	}
+}
+
+func (p *hostPool) setHealthy(ep *hostEndpoint, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if healthy == ep.healthy {
+		return
+	}
+	ep.healthy = healthy
+	if healthy {
+		// the host recovered, don't wait for the ejection to expire
+		ep.ejectedUntil = time.Time{}
+		ep.failures = 0
+		p.log.Infof("host %s is healthy", ep.host)
+	} else {
+		p.log.Warnf("host %s is unhealthy", ep.host)
+	}
+}
+
+func (p *hostPool) startHealthCheck(httpClient *http.Client) {
+	p.checker = httpClient
+	go p.runHealthCheck()
+}
+
+func (p *hostPool) runHealthCheck() {
+	ticker := time.NewTicker(p.conf.HealthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.mu.Lock()
+		endpoints := append([]*hostEndpoint(nil), p.endpoints...)
+		p.mu.Unlock()
+
+		for _, ep := range endpoints {
+			err := p.check(ep)
+			if err != nil {
+				p.log.Debugf("health check of %s failed: %v", ep.host, err)
+			}
+			p.setHealthy(ep, err == nil)
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *hostPool) check(ep *hostEndpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.conf.HealthCheck.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.healthURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.checker.Do(req)
+	if err != nil {
+		return err
+	}
+	closing(resp.Body, p.log)
+
+	if resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func (p *hostPool) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refs++
+}
+
+// release closes the pool when the last worker using it is closed.
+func (p *hostPool) release() {
+	p.mu.Lock()
+	p.refs--
+	last := p.refs == 0
+	p.mu.Unlock()
+	if last {
+		p.close()
+	}
+}
+
+// close stops the health check and the discovery of the pool.
+func (p *hostPool) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// balancedClient is an output worker sending every batch to the host picked
+// by the pool. Every worker has its own client per host, as clients are not
+// safe for concurrent use.
+type balancedClient struct {
+	pool      *hostPool
+	newClient func(host string) (*client, error)
+	clients   map[*hostEndpoint]*client
+	backoff   backoff.Backoff
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBalancedClient(pool *hostPool, newClient func(host string) (*client, error), conf *backoffConfig) *balancedClient {
+	pool.acquire()
+	done := make(chan struct{})
+	return &balancedClient{
+		pool:      pool,
+		newClient: newClient,
+		clients:   make(map[*hostEndpoint]*client),
+		backoff:   newRetryBackoff(done, conf),
+		done:      done,
+	}
+}
+
+// Connect connects the host clients when they are first used.
+func (b *balancedClient) Connect() error {
+	return nil
+}
+
+func (b *balancedClient) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.pool.release()
+	})
+
+	var errs []error
+	for _, cli := range b.clients {
+		if err := cli.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *balancedClient) String() string {
+	return "httpout(balance)"
+}
+
+func (b *balancedClient) Publish(ctx context.Context, batch publisher.Batch) error {
+	b.closeRemoved()
+
+	ep := b.pool.pick(time.Now())
+	if ep == nil {
+		batch.Cancelled()
+		b.backoff.Wait()
+		if b.pool.size() == 0 {
+			// no host is resolved yet
+			return ErrNotConnected
+		}
+		// the circuit breakers of all hosts are open
+		return ErrCircuitOpen
+	}
+	cli, err := b.client(ep)
+	if err != nil {
+		batch.Retry()
+		b.pool.report(ep, err)
+		return err
+	}
+	b.backoff.Reset()
+
+	err = cli.Publish(ctx, batch)
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		// another worker is probing the host
+	case errors.Is(err, errPartialSuccess):
+		// the host is up, it rejected a part of the events
+		b.pool.report(ep, nil)
+	default:
+		b.pool.report(ep, err)
+	}
+	return err
+}
+
+// closeRemoved closes the clients of the hosts removed by the discovery.
+func (b *balancedClient) closeRemoved() {
+	for ep, cli := range b.clients {
+		if !b.pool.isRemoved(ep) {
+			continue
+		}
+		if err := cli.Close(); err != nil {
+			b.pool.log.Errorf("close client of %s: %v", ep.host, err)
+		}
+		delete(b.clients, ep)
+	}
+}
+
+func (b *balancedClient) client(ep *hostEndpoint) (*client, error) {
+	if cli, ok := b.clients[ep]; ok {
+		return cli, nil
+	}
+
+	cli, err := b.newClient(ep.host)
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.Connect(); err != nil {
+		return nil, err
+	}
+	b.clients[ep] = cli
+	return cli, nil
+}
+
+var _ outputs.NetworkClient = (*balancedClient)(nil)
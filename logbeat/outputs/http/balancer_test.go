@@ -0,0 +1,298 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func newTestPool(t *testing.T, conf balanceConfig, hosts ...string) *hostPool {
+	t.Helper()
+	return newBreakerPool(t, conf, nil, hosts...)
+}
+
+func newBreakerPool(t *testing.T, conf balanceConfig, breakers *circuitBreakers, hosts ...string) *hostPool {
+	t.Helper()
+	pool, err := newHostPool(&conf, "http", channelOpenObserve, hosts, breakers, logp.NewLogger(loggerName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.close)
+	return pool
+}
+
+// picks returns the hosts of n picks at now.
+func picks(pool *hostPool, now time.Time, n int) string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		if ep := pool.pick(now); ep != nil {
+			hosts[i] = ep.host
+		}
+	}
+	return strings.Join(hosts, ",")
+}
+
+func TestHostPoolPick(t *testing.T) {
+	conf := defaultBalanceConfig
+	conf.BackupHosts = []string{"c"}
+	conf.HostWeights = []hostWeight{{Host: "a", Weight: 2}}
+	pool := newTestPool(t, conf, "a", "b")
+	now := time.Now()
+
+	// smooth weighted round robin interleaves the hosts
+	if got := picks(pool, now, 6); got != "a,b,a,a,b,a" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// the backup host takes the traffic when no primary host is healthy
+	a, b, c := pool.endpoints[0], pool.endpoints[1], pool.endpoints[2]
+	pool.setHealthy(a, false)
+	if got := picks(pool, now, 2); got != "b,b" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+	pool.setHealthy(b, false)
+	if got := picks(pool, now, 2); got != "c,c" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// all hosts are picked when none is healthy
+	pool.setHealthy(c, false)
+	if got := picks(pool, now, 4); !strings.Contains(got, "a") || !strings.Contains(got, "b") || !strings.Contains(got, "c") {
+		t.Fatalf("unexpected picks %s", got)
+	}
+	pool.setHealthy(b, true)
+	if got := picks(pool, now, 2); got != "b,b" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+}
+
+func TestHostPoolEject(t *testing.T) {
+	conf := defaultBalanceConfig
+	conf.MaxFailures = 2
+	conf.EjectDuration = time.Minute
+	pool := newTestPool(t, conf, "a", "b")
+	a := pool.endpoints[0]
+	failure := errors.New("failed")
+
+	// a success resets the consecutive failures
+	pool.report(a, failure)
+	pool.report(a, nil)
+	pool.report(a, failure)
+	if got := picks(pool, time.Now(), 2); got != "a,b" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	pool.report(a, failure)
+	now := time.Now()
+	if got := picks(pool, now, 3); got != "b,b,b" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// the host is picked again after eject_duration
+	if got := picks(pool, now.Add(conf.EjectDuration), 2); !strings.Contains(got, "a") {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// or when the health check reports it recovered
+	pool.report(a, failure)
+	pool.report(a, failure)
+	pool.setHealthy(a, false)
+	pool.setHealthy(a, true)
+	if got := picks(pool, now, 2); !strings.Contains(got, "a") {
+		t.Fatalf("unexpected picks %s", got)
+	}
+}
+
+func TestHostPoolBreakers(t *testing.T) {
+	breakerConf := breakerConfig{Enabled: true, FailureThreshold: 1, OpenDuration: time.Hour}
+	breakers := newCircuitBreakers(&breakerConf, monitoring.NewRegistry(), logp.NewLogger(loggerName))
+
+	conf := defaultBalanceConfig
+	conf.BackupHosts = []string{"c"}
+	conf.HostWeights = []hostWeight{{Host: "a", Weight: 5}}
+	pool := newBreakerPool(t, conf, breakers, "a", "b")
+	a, b, c := pool.endpoints[0], pool.endpoints[1], pool.endpoints[2]
+	if a.breaker != breakers.host(channelOpenObserve, "http://a/api/default/default/_json") {
+		t.Fatal("the breaker is not shared with the clients of the host")
+	}
+	now := time.Now()
+
+	// the host of the lower weight takes the traffic while the breaker of
+	// the other one is open
+	a.breaker.report(true)
+	if got := picks(pool, now, 6); got != "b,b,b,b,b,b" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// then the backup host
+	b.breaker.report(true)
+	if got := picks(pool, now, 2); got != "c,c" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+
+	// the unhealthy hosts are picked before none
+	pool.setHealthy(c, false)
+	c.breaker.report(false)
+	if got := picks(pool, now, 2); got != "c,c" {
+		t.Fatalf("unexpected picks %s", got)
+	}
+	c.breaker.report(true)
+	if ep := pool.pick(now); ep != nil {
+		t.Fatalf("expected no host, got %s", ep.host)
+	}
+}
+
+func TestBalancedClientBreakers(t *testing.T) {
+	var failed, accepted atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted.Add(1)
+	}))
+	defer up.Close()
+	downHost := strings.TrimPrefix(down.URL, "http://")
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":                             []string{downHost, strings.TrimPrefix(up.URL, "http://")},
+		"host_weights":                      []map[string]any{{"host": downHost, "weight": 5}},
+		"channel":                           "openobserve",
+		"loadbalance":                       false,
+		"backoff.init":                      "1ms",
+		"backoff.max":                       "1ms",
+		"circuit_breaker.enabled":           true,
+		"circuit_breaker.failure_threshold": 1,
+		"circuit_breaker.open_duration":     "1h",
+	})
+
+	// the first batch opens the breaker of the host of the higher weight
+	if err := sendTestEvents(output, 1, 1); err == nil {
+		t.Fatal("expected the first batch to fail")
+	}
+	for i := 0; i < 5; i++ {
+		if err := sendTestEvents(output, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed.Load() != 1 || accepted.Load() != 5 {
+		t.Fatalf("expected 1 failed and 5 accepted requests, got %d and %d", failed.Load(), accepted.Load())
+	}
+}
+
+func TestHostPoolHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	b := strings.TrimPrefix(other.URL, "http://")
+
+	conf := defaultBalanceConfig
+	conf.HealthCheck = healthCheckConfig{Enabled: true, Path: "/healthz", Interval: 10 * time.Millisecond, Timeout: time.Second}
+	pool := newTestPool(t, conf, strings.TrimPrefix(srv.URL, "http://"), b)
+	ep := pool.endpoints[0]
+	pool.startHealthCheck(srv.Client())
+
+	waitHealthy := func(expected bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			pool.mu.Lock()
+			got := ep.healthy
+			pool.mu.Unlock()
+			if got == expected {
+				return
+			}
+		}
+		t.Fatalf("expected healthy %v", expected)
+	}
+
+	waitHealthy(false)
+	if got := picks(pool, time.Now(), 2); got != b+","+b {
+		t.Fatalf("unexpected picks %s", got)
+	}
+	healthy.Store(true)
+	waitHealthy(true)
+}
+
+func TestBalancedClientPartialSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"text":"Invalid data format","code":6,"invalid-event-number":1}`))
+	}))
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":            []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":          "splunk_hec",
+		"batch_mode":       true,
+		"max_failures":     1,
+		"splunk_hec.token": "token",
+	})
+	if err := sendTestEvents(output, 1, 4); !errors.Is(err, errPartialSuccess) {
+		t.Fatalf("expected a partial success, got %v", err)
+	}
+
+	// the host answered, it is not ejected
+	pool := output.(*balancedClient).pool
+	if ep := pool.endpoints[0]; !ep.available(time.Now()) || ep.failures != 0 {
+		t.Fatalf("expected the host to stay available, %d failures", ep.failures)
+	}
+}
+
+func TestBalancedClientClose(t *testing.T) {
+	pool := newTestPool(t, defaultBalanceConfig, "a", "b")
+	workers := []*balancedClient{
+		newBalancedClient(pool, nil, &defaultConfig.Backoff),
+		newBalancedClient(pool, nil, &defaultConfig.Backoff),
+	}
+
+	closed := func() bool {
+		select {
+		case <-pool.done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// the pool is closed with the last worker
+	_ = workers[0].Close()
+	_ = workers[0].Close()
+	if closed() {
+		t.Fatal("expected the pool to be running while a worker uses it")
+	}
+	_ = workers[1].Close()
+	if !closed() {
+		t.Fatal("expected the pool to be closed")
+	}
+}
+
+func TestBalanceConfig(t *testing.T) {
+	for _, settings := range []map[string]any{
+		{"eject_duration": "0s"},
+		{"eject_duration": "-1s"},
+		{"health_check.enabled": true, "health_check.interval": "0s"},
+		{"health_check.enabled": true, "health_check.interval": "-1s"},
+		{"health_check.enabled": true, "health_check.timeout": "0s"},
+	} {
+		settings["max_failures"] = 1
+		conf := defaultConfig
+		if err := config.MustNewConfigFrom(settings).Unpack(&conf); err == nil {
+			t.Errorf("expected an error for %v", settings)
+		}
+	}
+}
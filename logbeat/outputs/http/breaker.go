@@ -0,0 +1,248 @@
+package http
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// The states of a circuit breaker, reported by the circuit_breaker.state metric.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = [...]string{"closed", "open", "half-open"}
+
+type breakerConfig struct {
+	Enabled bool `config:"enabled"`
+	// FailureThreshold is the number of consecutive failed batches opening
+	// the breaker.
+	FailureThreshold int `config:"failure_threshold" validate:"min=1"`
+	// OpenDuration is the time before a batch probes the host again.
+	OpenDuration time.Duration `config:"open_duration"`
+}
+
+var defaultBreakerConfig = breakerConfig{
+	FailureThreshold: 5,
+	OpenDuration:     30 * time.Second,
+}
+
+func (c *breakerConfig) Validate() error {
+	if c.OpenDuration <= 0 {
+		return errors.New("circuit_breaker.open_duration must be positive")
+	}
+	return nil
+}
+
+// circuitBreakers holds the breakers of the output by host, the clients of
+// all workers sending to a host share its breaker.
+type circuitBreakers struct {
+	conf *breakerConfig
+	reg  *monitoring.Registry
+	log  *logp.Logger
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// newCircuitBreakers returns nil when the breaker is disabled.
+func newCircuitBreakers(conf *breakerConfig, reg *monitoring.Registry, log *logp.Logger) *circuitBreakers {
+	if !conf.Enabled {
+		return nil
+	}
+	return &circuitBreakers{
+		conf:     conf,
+		reg:      reg,
+		log:      log,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+// host returns the breaker of the host of rawURL, registering its metrics as
+// <channel>.<host>.circuit_breaker.
+func (s *circuitBreakers) host(channel, rawURL string) *circuitBreaker {
+	if s == nil {
+		return nil
+	}
+	name := hostRegistryName(channel, rawURL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.breakers[name]; ok {
+		return b
+	}
+
+	reg := subRegistry(subRegistry(s.reg, name), "circuit_breaker")
+	b := &circuitBreaker{
+		conf:    s.conf,
+		log:     s.log,
+		host:    maskURL(rawURL),
+		now:     time.Now,
+		changed: make(chan struct{}),
+		state:   monitoring.NewInt(reg, "state"),
+		opened:  monitoring.NewInt(reg, "opened"),
+	}
+	s.breakers[name] = b
+	return b
+}
+
+// circuitBreaker stops sending to a failing host. It opens after
+// failure_threshold consecutive failed batches, then lets one batch probe the
+// host every open_duration: the breaker closes when the probe succeeds and
+// opens again when it fails. All methods are no-ops on a nil breaker, which
+// is always closed.
+type circuitBreaker struct {
+	conf *breakerConfig
+	log  *logp.Logger
+	host string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	current  int
+	failures int
+	retryAt  time.Time
+	// changed is closed and replaced on every state change
+	changed chan struct{}
+
+	state  *monitoring.Int
+	opened *monitoring.Int
+}
+
+// ready reports whether a batch can be sent now, without changing the state.
+func (b *circuitBreaker) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readyLocked()
+}
+
+func (b *circuitBreaker) readyLocked() bool {
+	switch b.current {
+	case breakerOpen:
+		return !b.now().Before(b.retryAt)
+	case breakerHalfOpen:
+		// the probe is in flight
+		return false
+	}
+	return true
+}
+
+// allow reports whether a batch can be sent. When the open duration has
+// passed, the caller sends the probe and the breaker is half-open until the
+// probe is reported.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.readyLocked() {
+		return false
+	}
+	if b.current == breakerOpen {
+		b.setState(breakerHalfOpen)
+	}
+	return true
+}
+
+// report records the result of a batch allowed by the breaker.
+func (b *circuitBreaker) report(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		if b.current != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.current == breakerHalfOpen:
+	case b.current == breakerClosed && b.failures >= b.conf.FailureThreshold:
+	default:
+		return
+	}
+	b.retryAt = b.now().Add(b.conf.OpenDuration)
+	b.opened.Inc()
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(state int) {
+	switch state {
+	case breakerOpen:
+		b.log.Warnf("circuit breaker of %s opened after %d consecutive failures, probing again in %v",
+			b.host, b.failures, b.conf.OpenDuration)
+	case breakerClosed:
+		b.log.Infof("circuit breaker of %s closed", b.host)
+	default:
+		b.log.Debugf("circuit breaker of %s is %s", b.host, breakerStateNames[state])
+	}
+
+	b.current = state
+	b.state.Set(int64(state))
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wait waits until the breaker is ready, at most limit. It reports whether
+// the breaker is ready.
+func (b *circuitBreaker) wait(done <-chan struct{}, limit time.Duration) bool {
+	if b == nil {
+		return true
+	}
+
+	timeout := time.NewTimer(limit)
+	defer timeout.Stop()
+
+	for {
+		b.mu.Lock()
+		if b.readyLocked() {
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed
+		var retryIn time.Duration
+		if b.current == breakerOpen {
+			retryIn = b.retryAt.Sub(b.now())
+		}
+		b.mu.Unlock()
+
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if retryIn > 0 {
+			timer = time.NewTimer(retryIn)
+			retry = timer.C
+		}
+
+		ok := true
+		select {
+		case <-done:
+			ok = false
+		case <-timeout.C:
+			ok = false
+		case <-changed:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !ok {
+			return false
+		}
+	}
+}
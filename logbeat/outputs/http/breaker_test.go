@@ -0,0 +1,134 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	conf := breakerConfig{Enabled: true, FailureThreshold: 2, OpenDuration: 10 * time.Second}
+	breakers := newCircuitBreakers(&conf, monitoring.NewRegistry(), logp.NewLogger(loggerName))
+	b := breakers.host(channelOpenObserve, "http://127.0.0.1:5080/api")
+	if breakers.host(channelOpenObserve, "http://127.0.0.1:5080/other") != b {
+		t.Fatal("the breaker is not shared by the clients of the host")
+	}
+
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	expect := func(state int, ready bool) {
+		t.Helper()
+		if b.current != state || b.state.Get() != int64(state) {
+			t.Fatalf("expected state %s, got %s (metric %d)", breakerStateNames[state], breakerStateNames[b.current], b.state.Get())
+		}
+		if b.ready() != ready {
+			t.Fatalf("expected ready %v in state %s", ready, breakerStateNames[state])
+		}
+	}
+
+	// a success resets the failures
+	b.report(true)
+	b.report(false)
+	b.report(true)
+	expect(breakerClosed, true)
+
+	b.report(true)
+	expect(breakerOpen, false)
+	if b.allow() {
+		t.Fatal("open breaker allowed a batch")
+	}
+
+	// the first batch after open_duration probes the host
+	now = now.Add(conf.OpenDuration)
+	expect(breakerOpen, true)
+	if !b.allow() {
+		t.Fatal("probe not allowed")
+	}
+	expect(breakerHalfOpen, false)
+	if b.allow() {
+		t.Fatal("second probe allowed")
+	}
+
+	// a failed probe opens the breaker again
+	b.report(true)
+	expect(breakerOpen, false)
+	now = now.Add(conf.OpenDuration)
+	if !b.allow() {
+		t.Fatal("probe not allowed")
+	}
+	b.report(false)
+	expect(breakerClosed, true)
+
+	if opened := b.opened.Get(); opened != 2 {
+		t.Fatalf("expected the breaker opened twice, got %d", opened)
+	}
+}
+
+func TestCircuitBreakerWait(t *testing.T) {
+	conf := breakerConfig{Enabled: true, FailureThreshold: 1, OpenDuration: 50 * time.Millisecond}
+	breakers := newCircuitBreakers(&conf, monitoring.NewRegistry(), logp.NewLogger(loggerName))
+	b := breakers.host("", "127.0.0.1:5080")
+
+	b.report(true)
+	if b.wait(make(chan struct{}), 10*time.Millisecond) {
+		t.Fatal("open breaker ready before open_duration")
+	}
+	if !b.wait(make(chan struct{}), time.Second) {
+		t.Fatal("breaker not ready after open_duration")
+	}
+
+	b.allow()
+	done := make(chan struct{})
+	close(done)
+	if b.wait(done, time.Second) {
+		t.Fatal("half-open breaker ready during the probe")
+	}
+}
+
+func TestCircuitBreakerPublish(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":                             []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":                           "openobserve",
+		"backoff.init":                      "1ms",
+		"backoff.max":                       "1ms",
+		"circuit_breaker.enabled":           true,
+		"circuit_breaker.failure_threshold": 2,
+		"circuit_breaker.open_duration":     "1h",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := sendTestEvents(output, 1, 1); err == nil {
+			t.Fatal("expected the request to fail")
+		}
+	}
+
+	batch := outest.NewBatch(createEvent(1))
+	if err := output.Publish(context.Background(), batch); err != ErrCircuitOpen {
+		t.Fatalf("expected %v, got %v", ErrCircuitOpen, err)
+	}
+	if signals := batch.Signals; len(signals) != 1 || signals[0].Tag != outest.BatchCancelled {
+		t.Fatalf("expected the batch to be cancelled, got %v", signals)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+	if err := output.(*client).Connect(); err != ErrCircuitOpen {
+		t.Fatalf("expected connect to fail with %v, got %v", ErrCircuitOpen, err)
+	}
+}
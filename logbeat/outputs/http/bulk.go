@@ -0,0 +1,163 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+// bulk referrer: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+// the same api is served by OpenSearch, ZincSearch(/es/_bulk) and Quickwit(/api/v1/_elastic/_bulk).
+
+const (
+	bulkPath = "/_bulk"
+
+	bulkOpIndex  = "index"
+	bulkOpCreate = "create"
+)
+
+var errBulkResponse = errors.New("bulk response doesn't match the request")
+
+type bulkConfig struct {
+	Index    string `config:"index"`
+	OpType   string `config:"op_type"`
+	Pipeline string `config:"pipeline"`
+}
+
+type bulkMeta struct {
+	Id       string `json:"_id,omitempty"`
+	Index    string `json:"_index,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (c *bulkConfig) Validate() error {
+	switch c.OpType {
+	case "", bulkOpIndex, bulkOpCreate:
+		return nil
+	}
+	return fmt.Errorf("invalid es_bulk.op_type: %s", c.OpType)
+}
+
+// action returns the action line put in front of every document: {"index":{"_index":"xxx"}}
+func (c *bulkConfig) action() map[string]bulkMeta {
+	op := c.OpType
+	if op == "" {
+		op = bulkOpIndex
+	}
+	return map[string]bulkMeta{op: {Index: c.Index, Pipeline: c.Pipeline}}
+}
+
+// actionWithId returns the action line of the document id.
+func (c *bulkConfig) actionWithId(id string) map[string]bulkMeta {
+	action := c.action()
+	for op, meta := range action {
+		meta.Id = id
+		action[op] = meta
+	}
+	return action
+}
+
+// makeBulkDocument uses the json message as document, other messages are
+// wrapped into {"message":"xxx"}.
+func makeBulkDocument(v beat.Event, msg string) eventRaw {
+	var ret eventRaw
+	if err := json.Unmarshal(UnsafeStr2Bytes(msg), &ret); err != nil || ret == nil {
+		ret = eventRaw{"message": rawString(msg)}
+	}
+	if _, ok := ret["@timestamp"]; !ok {
+		ret["@timestamp"], _ = json.Marshal(v.Timestamp)
+	}
+	return ret
+}
+
+// publishBulk sends all events in one bulk request. The events rejected with
+// a retryable status are returned, the ones rejected permanently are dropped.
+func (c *client) publishBulk(ctx context.Context, data []publisher.Event, evts []eventRaw) ([]publisher.Event, error) {
+	action := c.settings.Bulk.action()
+	body := make([]any, 0, 2*len(evts))
+	for i, e := range evts {
+		if c.settings.Idempotency.EventIDs {
+			// the retried documents replace the indexed ones, or are
+			// reported as duplicates with op_type create
+			body = append(body, c.settings.Bulk.actionWithId(eventID(data[i].Content)), e)
+		} else {
+			body = append(body, action, e)
+		}
+	}
+
+	skipped, retry, err := c.doPublish(ctx, body, data)
+	if err != nil {
+		return data, err
+	}
+	if acked := len(data) - skipped - len(retry); acked > 0 {
+		c.observer.Acked(acked)
+	}
+	if !c.spooled {
+		c.replaySpool(ctx)
+	}
+	if len(retry) > 0 {
+		return retry, fmt.Errorf("%w: %d of %d bulk items failed", errPartialSuccess, len(retry), len(data))
+	}
+	return nil, nil
+}
+
+// checkBulkResponse returns the failed items of a bulk response of events as
+// a partial success: the items failed with 429 or a server error are retried,
+// the others are rejected, but the conflicts of op_type create which are
+// duplicates.
+func (c *client) checkBulkResponse(resp []byte, events int) error {
+	var result bulkResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	if len(result.Items) != events {
+		return errBulkResponse
+	}
+
+	partial := &partialError{}
+	for i, item := range result.Items {
+		var status int
+		var itemErr json.RawMessage
+		for _, v := range item {
+			status, itemErr = v.Status, v.Error
+		}
+
+		switch {
+		case status < 300:
+		case status == http.StatusConflict && c.settings.Bulk.OpType == bulkOpCreate:
+			partial.duplicates++
+		case status == http.StatusTooManyRequests:
+			partial.tooMany++
+			partial.retry = append(partial.retry, i)
+		case status >= 500:
+			partial.retry = append(partial.retry, i)
+		default:
+			if len(partial.rejected) == 0 {
+				partial.msg = fmt.Sprintf("status=%v: %s", status, itemErr)
+			}
+			partial.rejected = append(partial.rejected, i)
+		}
+	}
+	if partial.msg == "" {
+		partial.msg = fmt.Sprintf("%d of %d bulk items failed", len(partial.retry), events)
+	}
+	return partial
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+)
+
+func TestBulkFailures(t *testing.T) {
+	tests := []struct {
+		status int
+		cfg    map[string]any
+		// the events are dropped when not retried
+		retried bool
+	}{
+		{status: http.StatusBadRequest},
+		{status: http.StatusNotFound},
+		{status: http.StatusRequestEntityTooLarge},
+		{status: http.StatusRequestTimeout, retried: true},
+		{status: http.StatusTooManyRequests, retried: true},
+		{status: http.StatusServiceUnavailable, retried: true},
+		{status: http.StatusUnauthorized, cfg: map[string]any{"retry_statuses": []int{401}}, retried: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, `{"error":{"type":"illegal_argument_exception"},"status":`, test.status, `}`)
+			}))
+			defer srv.Close()
+
+			cfg := map[string]any{
+				"hosts":        []string{strings.TrimPrefix(srv.URL, "http://")},
+				"channel":      "es_bulk",
+				"backoff.init": "1ms",
+				"backoff.max":  "1ms",
+			}
+			for k, v := range test.cfg {
+				cfg[k] = v
+			}
+			output := newTestOutput(t, cfg)
+
+			batch := outest.NewBatch(createEvent(1), createEvent(2), createEvent(3))
+			err := output.Publish(context.Background(), batch)
+			dropped := output.(*client).settings.Drops.counters[dropRejected].Get()
+			if test.retried {
+				if err == nil || len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchRetryEvents || dropped != 0 {
+					t.Fatalf("expected the events to be retried, got %v, %v", err, batch.Signals)
+				}
+				return
+			}
+			if err != nil || len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchACK {
+				t.Fatalf("expected the batch to be acked, got %v, %v", err, batch.Signals)
+			}
+			if dropped != 3 {
+				t.Fatalf("expected 3 rejected events, got %d", dropped)
+			}
+		})
+	}
+}
+
+func TestBulkSpool(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, string(b))
+		fmt.Fprint(w, `{"errors":false,"items":[]}`)
+	}))
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":              []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":            "es_bulk",
+		"es_bulk.index":      "logs",
+		"backoff.init":       "1ms",
+		"backoff.max":        "1ms",
+		"spool.path":         t.TempDir(),
+		"spool.max_attempts": 2,
+	})
+	spool := output.(*client).settings.Spool
+
+	publish := func(ids ...int) {
+		t.Helper()
+		batch := outest.NewBatch(createEvent(ids[0]), createEvent(ids[1]))
+		if err := output.Publish(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchACK {
+			t.Fatalf("expected the batch to be acked, got %v", batch.Signals)
+		}
+	}
+
+	// the request failed max_attempts times is spooled, then replayed
+	publish(1, 2)
+	if n := spool.entriesGauge.Get(); n != 1 {
+		t.Fatalf("expected 1 spooled request, got %d", n)
+	}
+	publish(3, 4)
+	if n := spool.entriesGauge.Get(); n != 0 || spool.replayed.Get() != 2 {
+		t.Fatalf("expected the spooled request to be replayed, got %d entries", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || !strings.Contains(bodies[1], `{"index":{"_index":"logs"}}`) || !strings.Contains(bodies[1], "test 1") {
+		t.Fatalf("unexpected bodies %q", bodies)
+	}
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// clickhouse referrer: https://clickhouse.com/docs/en/interfaces/http
+
+const (
+	clickHouseTimestampField = "@timestamp"
+	clickHouseMessageField   = "message"
+	clickHouseTraceIdField   = "trace_id"
+	clickHouseJSONPrefix     = "json."
+)
+
+// clickHouseDataErrors are the exception codes caused by the inserted rows,
+// retrying the same rows can't succeed.
+var clickHouseDataErrors = map[int]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	16:  true, // NO_SUCH_COLUMN_IN_TABLE
+	26:  true, // CANNOT_PARSE_QUOTED_STRING
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	38:  true, // CANNOT_PARSE_DATE
+	41:  true, // CANNOT_PARSE_DATETIME
+	53:  true, // TYPE_MISMATCH
+	69:  true, // ARGUMENT_OUT_OF_BOUND
+	72:  true, // CANNOT_PARSE_NUMBER
+	117: true, // INCORRECT_DATA
+	131: true, // TOO_LARGE_STRING_SIZE
+}
+
+type clickHouseConfig struct {
+	Database           string            `config:"database"`
+	Table              string            `config:"table"`
+	Columns            map[string]string `config:"columns"`
+	AsyncInsert        bool              `config:"async_insert"`
+	WaitForAsyncInsert bool              `config:"wait_for_async_insert"`
+	Settings           map[string]string `config:"settings"`
+}
+
+// params returns the query parameters of the insert request, settings are
+// passed as query parameters too.
+func (c *clickHouseConfig) params() map[string]string {
+	params := map[string]string{
+		"query":                            "INSERT INTO " + c.Table + " FORMAT JSONEachRow",
+		"date_time_input_format":           "best_effort",
+		"input_format_skip_unknown_fields": "1",
+	}
+	if c.Database != "" {
+		params["database"] = c.Database
+	}
+	if c.AsyncInsert {
+		params["async_insert"] = "1"
+		if c.WaitForAsyncInsert {
+			params["wait_for_async_insert"] = "1"
+		} else {
+			params["wait_for_async_insert"] = "0"
+		}
+	}
+	for key, val := range c.Settings {
+		params[key] = val
+	}
+	return params
+}
+
+// makeClickHouseRow maps the event to the table columns. Without columns the
+// json message is inserted as is.
+func makeClickHouseRow(v beat.Event, msg string, columns map[string]string) (eventRaw, error) {
+	var msgFields eventRaw
+	if len(columns) == 0 {
+		if err := json.Unmarshal(UnsafeStr2Bytes(msg), &msgFields); err != nil {
+			return nil, err
+		}
+		return msgFields, nil
+	}
+
+	ret := make(eventRaw, len(columns))
+	for column, field := range columns {
+		switch {
+		case field == clickHouseTimestampField:
+			ret[column], _ = json.Marshal(v.Timestamp)
+		case field == clickHouseMessageField:
+			ret[column] = rawString(msg)
+		case field == clickHouseTraceIdField:
+			if traceId := getTraceId(msg); traceId != "" {
+				ret[column] = rawString(traceId)
+			}
+		case strings.HasPrefix(field, clickHouseJSONPrefix):
+			if msgFields == nil {
+				if err := json.Unmarshal(UnsafeStr2Bytes(msg), &msgFields); err != nil {
+					return nil, err
+				}
+			}
+			if val, ok := msgFields[field[len(clickHouseJSONPrefix):]]; ok {
+				ret[column] = val
+			}
+		default:
+			val, err := v.Fields.GetValue(field)
+			if err != nil {
+				continue
+			}
+			if ret[column], err = json.Marshal(val); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// checkClickHouseResponse looks for an exception in the response body, which
+// may also be sent with status 200 when the insert failed after the response
+// started. Rows rejected because of their data are dropped.
+func (c *client) checkClickHouseResponse(resp []byte, err error) error {
+	code, msg := parseClickHouseException(resp)
+	if code == 0 {
+		return err
+	}
+
+	if clickHouseDataErrors[code] {
+		return &rejectedError{msg: msg}
+	}
+	return fmt.Errorf("clickhouse exception, %s", msg)
+}
+
+// parseClickHouseException parses: Code: 60. DB::Exception: Table default.logs does not exist. (UNKNOWN_TABLE) (version 23.8.1.1)
+func parseClickHouseException(resp []byte) (int, string) {
+	idx := bytes.Index(resp, []byte("Code: "))
+	if idx < 0 || !bytes.Contains(resp[idx:], []byte("Exception")) {
+		return 0, ""
+	}
+	msg := strings.TrimSpace(string(resp[idx:]))
+
+	codeStr := msg[6:]
+	if end := strings.IndexByte(codeStr, '.'); end >= 0 {
+		codeStr = codeStr[:end]
+	}
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return 0, ""
+	}
+	return code, msg
+}
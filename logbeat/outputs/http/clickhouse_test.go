@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestClickHouse(t *testing.T) {
+	var mu sync.Mutex
+	var query url.Values
+	var body string
+	response := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		query, body = r.URL.Query(), string(b)
+		fmt.Fprint(w, response)
+	}))
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":                   []string{strings.TrimPrefix(srv.URL, "http://")},
+		"path":                    "/?user=logbeat",
+		"channel":                 "clickhouse",
+		"batch_mode":              true,
+		"backoff.init":            "1ms",
+		"backoff.max":             "1ms",
+		"clickhouse.database":     "app",
+		"clickhouse.table":        "logs",
+		"clickhouse.async_insert": true,
+		"clickhouse.settings":     map[string]any{"insert_deduplicate": "0"},
+		"clickhouse.columns": map[string]any{
+			"ts":     "@timestamp",
+			"msg":    "message",
+			"trace":  "trace_id",
+			"level":  "json.level",
+			"host":   "host.name",
+			"absent": "json.absent",
+		},
+	})
+
+	publish := func() []outest.BatchSignal {
+		t.Helper()
+		events := []beat.Event{
+			goldenEvent(`{"level":"info","trace_id":"abc"}`),
+			goldenEvent(`{"level":"error"}`),
+		}
+		batch := outest.NewBatch(events...)
+		_ = output.Publish(context.Background(), batch)
+		return batch.Signals
+	}
+
+	if signals := publish(); len(signals) != 1 || signals[0].Tag != outest.BatchACK {
+		t.Fatalf("expected the batch to be acked, got %v", signals)
+	}
+	expected := url.Values{
+		"user":                             {"logbeat"},
+		"query":                            {"INSERT INTO logs FORMAT JSONEachRow"},
+		"database":                         {"app"},
+		"date_time_input_format":           {"best_effort"},
+		"input_format_skip_unknown_fields": {"1"},
+		"async_insert":                     {"1"},
+		"wait_for_async_insert":            {"0"},
+		"insert_deduplicate":               {"0"},
+	}
+	if query.Encode() != expected.Encode() {
+		t.Errorf("unexpected query %s", query.Encode())
+	}
+	rows := strings.Split(strings.TrimSpace(body), "\n")
+	if len(rows) != 2 ||
+		rows[0] != `{"host":"web-1","level":"info","msg":"{\"level\":\"info\",\"trace_id\":\"abc\"}","trace":"abc","ts":"2024-05-01T12:30:45.123Z"}` ||
+		rows[1] != `{"host":"web-1","level":"error","msg":"{\"level\":\"error\"}","ts":"2024-05-01T12:30:45.123Z"}` {
+		t.Errorf("unexpected rows:\n%s", body)
+	}
+
+	// the exceptions of the data are rejected, the others are retried
+	tests := []struct {
+		exception string
+		tag       outest.BatchSignalTag
+	}{
+		{"Code: 27. DB::Exception: Cannot parse input: expected '\"' before: 'x'. (CANNOT_PARSE_INPUT_ASSERTION_FAILED)", outest.BatchACK},
+		{"Code: 60. DB::Exception: Table app.logs does not exist. (UNKNOWN_TABLE)", outest.BatchRetryEvents},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		response = test.exception
+		mu.Unlock()
+		if signals := publish(); len(signals) != 1 || signals[0].Tag != test.tag {
+			t.Errorf("%s: expected signal %v, got %v", test.exception, test.tag, signals)
+		}
+	}
+	if n := output.(*client).settings.Drops.counters[dropRejected].Get(); n != 2 {
+		t.Errorf("expected 2 rejected rows, got %d", n)
+	}
+
+	// the json message is the row without columns
+	row, err := makeClickHouseRow(beat.Event{Fields: mapstr.M{}}, `{"a":1}`, nil)
+	if err != nil || string(row["a"]) != "1" || len(row) != 1 {
+		t.Errorf("unexpected row %v: %v", row, err)
+	}
+	if _, err = makeClickHouseRow(beat.Event{Fields: mapstr.M{}}, `text`, nil); err == nil {
+		t.Error("expected an error for a text message without columns")
+	}
+}
@@ -20,12 +20,17 @@ package http
 import (
 	"context"
 	"encoding/json"
-	"github.com/elastic/elastic-agent-libs/logp"
+	"errors"
+	"fmt"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 	"unsafe"
 
+	"github.com/google/uuid"
+
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common/backoff"
 	"github.com/elastic/beats/v7/libbeat/outputs"
@@ -35,46 +40,118 @@ import (
 type clientSettings struct {
 	Username         string
 	Password         string
+	PasswordFile     string
+	BearerToken      string
+	BearerTokenFile  string
+	OAuth2           *oauth2Config
 	Headers          map[string]string
+	HeaderFiles      map[string]string
+	ParamFiles       map[string]string
 	CompressionLevel int
 	URL              string
 	BatchMode        bool
+	MaxBatchEvents   int
+	MaxBatchBytes    int
 	Channel          string
 	AppId            string
-
+	AppIdFile        string
+	SplunkHEC        hecConfig
+	Bulk             bulkConfig
+	OTLP             otlpConfig
+	Datadog          datadogConfig
+	NewRelic         newRelicConfig
+	ClickHouse       clickHouseConfig
+	Webhook          webhookConfig
+	Signer           signerConfig
+	Idempotency      idempotencyConfig
+	RetryStatuses    []int
+	Backoff          backoffConfig
+	AttemptTimeout   time.Duration
+
+	Beat      beat.Info
 	Transport httpcommon.HTTPTransportSettings
 	Observer  outputs.Observer
+	Limiter   *outputLimiter
+	Metrics   *outputMetrics
+	Drops     *dropStats
+	Debug     *debugCapture
+	Spool     *spool
+	Breakers  *circuitBreakers
 }
 
 type client struct {
 	url       string
 	batchMode bool
-	channel   string
-	appId     string
+	settings  clientSettings
 
 	conn     *Connection
 	observer outputs.Observer
 	done     chan struct{}
 	backoff  backoff.Backoff
+	breaker  *circuitBreaker
+
+	hecAckURL string
+	webhook   *webhookNotifier
+
+	// spooled is set when a request of the current batch is spooled
+	spooled bool
 }
 
 func newClient(s clientSettings) (*client, error) {
+	switch s.Channel {
+	case channelSplunkHEC:
+		if s.SplunkHEC.Channel == "" {
+			s.SplunkHEC.Channel = uuid.NewString()
+		}
+		s.Headers = s.SplunkHEC.headers(s.Headers)
+		if s.SplunkHEC.Raw {
+			var err error
+			s.URL, err = s.SplunkHEC.rawURL(s.URL)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case channelDatadog:
+		s.Headers = s.Datadog.headers(s.Headers)
+	case channelNewRelic:
+		s.Headers = s.NewRelic.headers(s.Headers)
+	case channelClickHouse:
+		s.URL = addToURL(s.URL, "", s.ClickHouse.params())
+	}
+
 	conn, err := NewConnection(&s)
 	if err != nil {
 		return nil, err
 	}
 
+	conn.stats = s.Metrics.host(s.Channel, s.URL)
+
 	done := make(chan struct{})
 
 	cli := &client{
 		conn:      conn,
 		observer:  s.Observer,
 		done:      done,
-		backoff:   backoff.NewEqualJitterBackoff(done, backoffInit, backoffMax),
 		url:       s.URL,
 		batchMode: s.BatchMode,
-		channel:   s.Channel,
-		appId:     s.AppId,
+		settings:  s,
+	}
+	cli.backoff = newRetryBackoff(done, &cli.settings.Backoff)
+	cli.breaker = s.Breakers.host(s.Channel, s.URL)
+
+	if s.Channel == channelSplunkHEC && s.SplunkHEC.Ack {
+		cli.hecAckURL, err = makeHECAckURL(s.URL, s.SplunkHEC.Channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Channel == channelWebhook {
+		cli.webhook, err = newWebhookNotifier(&cli.settings.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		go cli.runWebhook()
 	}
 
 	return cli, nil
@@ -83,6 +160,12 @@ func newClient(s clientSettings) (*client, error) {
 // Connect establishes a connection to the clients sink.
 func (c *client) Connect() error {
 	c.conn.log.Info("Connect")
+	// While the breaker is open the connection fails after a short wait, so
+	// the failover client tries the next host and the other load balanced
+	// workers take the batches.
+	if !c.breaker.wait(c.done, c.settings.Backoff.Init) {
+		return ErrCircuitOpen
+	}
 	return c.conn.Connect()
 }
 
@@ -91,15 +174,24 @@ func (c *client) Close() error {
 	c.conn.log.Info("Close")
 	err := c.conn.Close()
 	close(c.done)
+	if debugErr := c.settings.Debug.close(); err == nil {
+		err = debugErr
+	}
 	return err
 }
 
 func (c *client) String() string {
-	return "httpout(" + c.url + ")"
+	return "httpout(" + maskURL(c.url) + ")"
 }
 
 // Publish sends events to the clients sink.
 func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	if !c.breaker.allow() {
+		// return the batch to the queue without decreasing its retries
+		batch.Cancelled()
+		return ErrCircuitOpen
+	}
+
 	events := batch.Events()
 	c.observer.NewBatch(len(events))
 	rest, err := c.publishEvents(ctx, events)
@@ -110,6 +202,12 @@ func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
 		batch.ACK()
 	}
 
+	// a spooled request failed all its attempts
+	c.breaker.report((err != nil && !errors.Is(err, errPartialSuccess)) || c.spooled)
+	if !c.breaker.ready() {
+		// the host is skipped until the probe, don't wait for it here
+		return err
+	}
 	backoff.WaitOnError(c.backoff, err)
 	return err
 }
@@ -117,16 +215,25 @@ func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
 // publishEvents posts all events to the http endpoint. On error a slice with all
 // events not published will be returned.
 func (c *client) publishEvents(ctx context.Context, data []publisher.Event) ([]publisher.Event, error) {
+	c.spooled = false
 	szTotal := len(data)
 	if szTotal == 0 {
 		return nil, nil
 	}
 
-	okEvents, evts := extractDataFromEvent(c.conn.log, data, c.channel, c.appId)
-	dropped := szTotal - len(okEvents)
-	c.conn.log.Debugf("[check data] total: %d, dropped: %d", szTotal, dropped)
-	if dropped > 0 {
-		c.observer.Dropped(dropped)
+	if c.conn.secrets.appId != nil {
+		appId, err := c.conn.secrets.appId.Get()
+		if err != nil {
+			return data, err
+		}
+		c.settings.AppId = appId
+	}
+
+	okEvents, evts := extractDataFromEvent(c.settings.Drops, data, &c.settings)
+	invalid := szTotal - len(okEvents)
+	c.conn.log.Debugf("[check data] total: %d, dropped: %d", szTotal, invalid)
+	if invalid > 0 {
+		c.observer.Dropped(invalid)
 	}
 
 	count := len(evts)
@@ -134,64 +241,384 @@ func (c *client) publishEvents(ctx context.Context, data []publisher.Event) ([]p
 		return nil, nil
 	}
 
+	if c.settings.Channel == channelESBulk {
+		// bulk requests are always sent in batch, the response reports every item
+		return c.publishBulk(ctx, okEvents, evts)
+	}
+	if c.settings.Channel == channelWebhook {
+		return c.publishWebhook(okEvents, evts)
+	}
+
+	// the events dropped or failed by doPublish are not acked
+	skipped := 0
+	var retry []publisher.Event
 	if c.batchMode {
-		err := c.doPublish(evts)
-		if err != nil {
-			return okEvents, err
+		for start := 0; start < count; {
+			end := c.nextBatchEnd(evts, start)
+			n, failed, err := c.doPublish(ctx, evts[start:end], okEvents[start:end])
+			if err != nil {
+				if start > skipped {
+					c.observer.Acked(start - skipped)
+				}
+				return append(retry, okEvents[start:]...), err
+			}
+			skipped += n + len(failed)
+			retry = append(retry, failed...)
+			start = end
 		}
 	} else {
 		for i, event := range evts {
-			err := c.doPublish(event)
+			n, failed, err := c.doPublish(ctx, event, okEvents[i:i+1])
 			if err != nil {
-				if i > 0 {
-					c.observer.Acked(i)
+				if i > skipped {
+					c.observer.Acked(i - skipped)
 				}
-				return okEvents[i:], err
+				return append(retry, okEvents[i:]...), err
 			}
+			skipped += n + len(failed)
+			retry = append(retry, failed...)
 		}
 	}
 
-	c.observer.Acked(count)
+	if count > skipped {
+		c.observer.Acked(count - skipped)
+	}
+
+	if !c.spooled {
+		// the backend is up, send the requests spooled during the outage
+		c.replaySpool(ctx)
+	}
+	if len(retry) > 0 {
+		return retry, fmt.Errorf("%w: %d of %d events failed", errPartialSuccess, len(retry), count)
+	}
 	return nil, nil
 }
 
-func (c *client) doPublish(body any) error {
-	_, _, err := c.conn.RequestURL(http.MethodPost, c.url, body)
-	if err != nil {
-		if err == ErrEncodeFailed {
-			// don't retry unencodable values
-			return nil
+// nextBatchEnd returns the end of the request starting at evts[start], keeping
+// the request in the count and size limits. A request has at least one event.
+func (c *client) nextBatchEnd(evts []eventRaw, start int) int {
+	end := len(evts)
+	if limit := c.settings.MaxBatchEvents; limit > 0 && end-start > limit {
+		end = start + limit
+	}
+	if limit := c.settings.MaxBatchBytes; limit > 0 {
+		size := 0
+		for i := start; i < end; i++ {
+			size += evts[i].size()
+			if size > limit && i > start {
+				return i
+			}
+		}
+	}
+	return end
+}
+
+// doPublish sends the events of body, it returns the number of events not
+// acked, dropped because they can't be encoded or are rejected permanently or
+// accepted as duplicates, and the events to retry when the server accepted a
+// part of them. With a spool, the request is retried max_attempts times before
+// its body is spooled.
+func (c *client) doPublish(ctx context.Context, body any, data []publisher.Event) (int, []publisher.Event, error) {
+	var key string
+	if c.settings.Idempotency.Header != "" {
+		key = requestKey(data)
+	}
+
+	dropped, status, req, err := c.publishRequest(ctx, body, data, key)
+	var partial *partialError
+	if errors.As(err, &partial) {
+		dropped, retry := c.partialOutcome(data, partial)
+		return dropped, retry, nil
+	}
+	spool := c.settings.Spool
+	if err == nil || spool == nil {
+		return dropped, nil, err
+	}
+
+	for attempt := 1; attempt < spool.conf.MaxAttempts && retryableStatus(status); attempt++ {
+		c.conn.log.Debugf("request failed (attempt %d): %v", attempt, err)
+		if !c.backoff.Wait() {
+			return 0, nil, err
+		}
+		dropped, status, req, err = c.publishRequest(ctx, body, data, key)
+		if errors.As(err, &partial) {
+			c.backoff.Reset()
+			dropped, retry := c.partialOutcome(data, partial)
+			return dropped, retry, nil
+		}
+		if err == nil {
+			c.backoff.Reset()
+			return dropped, nil, nil
+		}
+	}
+	if !retryableStatus(status) || req == nil {
+		return 0, nil, err
+	}
+
+	events, _ := bodyStats(body)
+	if spoolErr := spool.add(req, events); spoolErr != nil {
+		c.conn.log.Errorf("Failed to spool request: %v", spoolErr)
+		return 0, nil, err
+	}
+	c.conn.log.Warnf("spooled %d events after %d failed attempts: %v", events, spool.conf.MaxAttempts, err)
+	c.spooled = true
+	return 0, nil, nil
+}
+
+// partialOutcome drops the events of data rejected by a partial success and
+// returns the number of events not acked and the events to retry, the other
+// events are acked by the caller.
+func (c *client) partialOutcome(data []publisher.Event, partial *partialError) (int, []publisher.Event) {
+	if partial.duplicates > 0 {
+		c.observer.Duplicate(partial.duplicates)
+	}
+	if partial.tooMany > 0 {
+		c.observer.ErrTooMany(partial.tooMany)
+	}
+
+	dropped := 0
+	for _, i := range partial.rejected {
+		if i < len(data) {
+			c.settings.Drops.event(dropRejected, data[i].Content, partial)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		c.observer.Dropped(dropped)
+	}
+
+	var retry []publisher.Event
+	for _, i := range partial.retry {
+		if i < len(data) {
+			retry = append(retry, data[i])
 		}
+	}
+	c.conn.log.Debugf("[partial] acked: %d, duplicates: %d, dropped: %d, failed: %d",
+		len(data)-partial.duplicates-dropped-len(retry), partial.duplicates, dropped, len(retry))
+	return partial.duplicates + dropped, retry
+}
+
+// publishRequest sends one request of body, the encoded events of data, with
+// the idempotency key. It returns the number of dropped events, the response
+// status and the sent request, nil if the body wasn't sent.
+func (c *client) publishRequest(ctx context.Context, body any, data []publisher.Event, key string) (int, int, *http.Request, error) {
+	c.conn.lastRequest = nil
+	events, bytes := bodyStats(body)
+	status, resp, err := c.send(ctx, events, bytes, func() (int, []byte, error) {
+		c.conn.idempotencyKey = key
+		defer func() { c.conn.idempotencyKey = "" }()
+		switch c.settings.Channel {
+		case channelClickHouse:
+			// JSONEachRow: one json object per line
+			return c.conn.Bulk(c.url, bulkBody(body))
+		case channelESBulk:
+			return c.conn.Bulk(c.url, body.([]any))
+		}
+		return c.conn.RequestURL(http.MethodPost, c.url, body)
+	})
+	req := c.conn.lastRequest
+	if err == ErrEncodeFailed {
+		// don't retry unencodable values
+		c.dropEvents(dropEncodeFailed, events)
+		return events, status, req, nil
+	}
+
+	err = c.checkResponse(status, resp, events, err)
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		if rejected.events > 0 && rejected.events < events {
+			events = rejected.events
+		}
+		c.dropEvents(dropRejected, events)
+		c.settings.Drops.logRejected(data, events, maskURL(c.url), rejected.msg)
+		return events, status, req, nil
+	}
+	if err != nil {
+		return 0, status, req, err
+	}
+
+	return 0, status, req, nil
+}
+
+// checkResponse returns the error of the response to a request of events.
+// Some servers report failed events with a success status. The requests
+// failed with a client error are rejected permanently, unless the status is
+// in retry_statuses.
+func (c *client) checkResponse(status int, resp []byte, events int, err error) error {
+	err = c.checkChannelResponse(resp, events, err)
+	if err == nil || !c.rejectedStatus(status) {
 		return err
 	}
 
-	//if c.channel == channelShushu {
-	//	var result struct {
-	//		Code int `json:"code"`
-	//	}
-	//	err = json.Unmarshal(resp, &result)
-	//	if err != nil {
-	//		result.Code = 1
-	//	}
-	//	if result.Code != 0 {
-	//		c.conn.log.Errorf("shushu publish fail, code: %d", result.Code)
-	//	}
-	//}
-
-	return nil
+	var rejected *rejectedError
+	var partial *partialError
+	if errors.As(err, &rejected) || errors.As(err, &partial) {
+		return err
+	}
+	return &rejectedError{msg: err.Error()}
+}
+
+func (c *client) checkChannelResponse(resp []byte, events int, err error) error {
+	// clickhouse and splunk hec explain the failed requests in the response
+	if err != nil && c.settings.Channel != channelClickHouse && c.settings.Channel != channelSplunkHEC {
+		return err
+	}
+
+	switch c.settings.Channel {
+	case channelClickHouse:
+		return c.checkClickHouseResponse(resp, err)
+	case channelSplunkHEC:
+		return c.checkHECResponse(resp, events, err)
+	case channelOpenObserve:
+		return checkOpenObserveResponse(resp)
+	case channelShushu:
+		return c.checkShushuResponse(resp)
+	case channelSa:
+		return checkSaResponse(resp)
+	case channelOTLP:
+		return c.checkOTLPResponse(resp)
+	case channelESBulk:
+		if err != nil {
+			return err
+		}
+		return c.checkBulkResponse(resp, events)
+	}
+	return err
+}
+
+// rejectedStatus reports whether a request failed with status won't succeed
+// when retried: a client error but 408, 429 and the retry_statuses.
+func (c *client) rejectedStatus(status int) bool {
+	if status < 400 || status >= 500 || retryableStatus(status) {
+		return false
+	}
+	return !slices.Contains(c.settings.RetryStatuses, status)
+}
+
+// replaySpool sends at most spool.replay_requests spooled requests oldest
+// first, until a request fails. The next requests are replayed after the next
+// published batch, so a long outage doesn't hold the worker. Only one client
+// replays at a time.
+func (c *client) replaySpool(ctx context.Context) {
+	spool := c.settings.Spool
+	if spool == nil || !spool.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	defer spool.replaying.Store(false)
+
+	for i := 0; i < spool.conf.ReplayRequests && ctx.Err() == nil; i++ {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		entry, body, header, ok := spool.oldest()
+		if !ok {
+			return
+		}
+
+		status, resp, err := c.send(ctx, entry.events, len(body), func() (int, []byte, error) {
+			return c.conn.RequestRaw(http.MethodPost, c.url, body, header)
+		})
+		err = c.checkResponse(status, resp, entry.events, err)
+
+		var rejected *rejectedError
+		var partial *partialError
+		switch {
+		case errors.As(err, &rejected):
+			c.settings.Drops.count(dropRejected, entry.events)
+			c.settings.Drops.logf("dropping %d spooled events rejected by %s: %s", entry.events, maskURL(c.url), rejected.msg)
+		case errors.As(err, &partial):
+			// the failed events can't be sent apart from the spooled body
+			dropped := len(partial.rejected) + len(partial.retry)
+			c.settings.Drops.count(dropRejected, dropped)
+			c.settings.Drops.logf("dropping %d of %d spooled events rejected by %s: %s", dropped, entry.events, maskURL(c.url), partial.msg)
+			spool.replayed.Add(int64(entry.events - dropped))
+		case err != nil:
+			c.conn.log.Debugf("spool replay failed: %v", err)
+			return
+		default:
+			spool.replayed.Add(int64(entry.events))
+		}
+		spool.remove(entry)
+	}
+}
+
+// dropEvents reports n events dropped for reason.
+func (c *client) dropEvents(reason string, n int) {
+	c.settings.Drops.count(reason, n)
+	c.observer.Dropped(n)
+}
+
+// send executes a request of events and bytes within the rate and
+// concurrency limits of the output.
+func (c *client) send(ctx context.Context, events, bytes int, request func() (int, []byte, error)) (int, []byte, error) {
+	c.conn.stats.sent(events, bytes)
+
+	limiter := c.settings.Limiter
+	if limiter == nil {
+		return request()
+	}
+
+	release, err := limiter.acquire(ctx, events, bytes)
+	if err != nil {
+		return 0, nil, err
+	}
+	status, resp, err := request()
+	release(status)
+	return status, resp, err
+}
+
+// bodyStats returns the number of events and their approximate size.
+func bodyStats(body any) (events, bytes int) {
+	switch v := body.(type) {
+	case []eventRaw:
+		for _, e := range v {
+			bytes += e.size()
+		}
+		return len(v), bytes
+	case eventRaw:
+		return 1, v.size()
+	case []any:
+		// bulk body of action and document pairs
+		for _, e := range v {
+			if raw, ok := e.(eventRaw); ok {
+				events++
+				bytes += raw.size()
+			}
+		}
+		return events, bytes
+	default:
+		return 1, 0
+	}
+}
+
+// bulkBody converts the events of doPublish to the body of Connection.Bulk.
+func bulkBody(body any) []any {
+	switch v := body.(type) {
+	case []eventRaw:
+		ret := make([]any, len(v))
+		for i, e := range v {
+			ret[i] = e
+		}
+		return ret
+	default:
+		return []any{v}
+	}
 }
 
 func extractDataFromEvent(
-	log *logp.Logger,
+	drops *dropStats,
 	data []publisher.Event,
-	channel, appId string,
+	s *clientSettings,
 ) ([]publisher.Event, []eventRaw) {
 	var okEvents []publisher.Event
 	var to []eventRaw
 	for _, event := range data {
-		e, err := makeEvent(event.Content, channel, appId)
+		e, err := makeEvent(event.Content, s)
 		if err != nil {
-			log.Errorf("httpout make event error: %v", err)
+			drops.event(dropReason(err), event.Content, err)
 			continue
 		}
 
@@ -201,7 +628,7 @@ func extractDataFromEvent(
 	return okEvents, to
 }
 
-func makeEvent(v beat.Event, channel string, appId string) (eventRaw, error) {
+func makeEvent(v beat.Event, s *clientSettings) (eventRaw, error) {
 	msgBody, err := getMessageBody(v)
 	if err != nil {
 		return nil, err
@@ -209,24 +636,43 @@ func makeEvent(v beat.Event, channel string, appId string) (eventRaw, error) {
 
 	var ret eventRaw
 
-	switch channel {
+	switch s.Channel {
 	case channelShushu: // {"appid":"xxx","data":{}}
 		// doc: https://docs.thinkingdata.cn/ta-manual/latest/installation/installation_menu/restful_api.html#_2-2-%E6%95%B0%E6%8D%AE%E6%8E%A5%E6%94%B6%E6%8E%A5%E5%8F%A3-%E6%8F%90%E4%BA%A4%E6%96%B9%E5%BC%8F%E4%B8%BA-raw
 		ret = make(eventRaw)
+		if s.Idempotency.EventIDs {
+			msgBody = withEventUUID(msgBody, v)
+		}
 		ret["data"] = json.RawMessage(msgBody)
-		ret["appid"] = json.RawMessage(strings.Join([]string{"\"", appId, "\""}, ""))
+		ret["appid"] = json.RawMessage(strings.Join([]string{"\"", s.AppId, "\""}, ""))
 	case channelOpenObserve: // {"log":"xxx"}
 		// doc: https://openobserve.ai/docs/api/stream/setting/
 		ret = make(eventRaw)
 		traceId := getTraceId(msgBody)
 		if traceId != "" {
-			ret["trace_id"] = json.RawMessage(strings.Join([]string{"\"", traceId, "\""}, ""))
+			ret["trace_id"] = rawString(traceId)
 		}
 		b, _ := json.Marshal(msgBody)
 		ret["log"] = b
-	case channelSa:
+	case channelSa, channelWebhook:
 		ret = make(eventRaw)
 		ret[originMsgKey] = json.RawMessage(msgBody)
+	case channelSplunkHEC:
+		// doc: https://docs.splunk.com/Documentation/Splunk/latest/Data/FormateventsforHTTPEventCollector
+		ret = makeHECEvent(v, msgBody, &s.SplunkHEC, s.Beat.Hostname)
+	case channelESBulk:
+		ret = makeBulkDocument(v, msgBody)
+	case channelOTLP:
+		ret = makeOTLPLogRecord(v, msgBody, &s.OTLP, time.Now())
+	case channelDatadog:
+		ret = makeDatadogEvent(v, msgBody, &s.Datadog, s.Beat.Hostname)
+	case channelNewRelic:
+		ret = makeNewRelicLog(v, msgBody)
+	case channelClickHouse:
+		ret, err = makeClickHouseRow(v, msgBody, s.ClickHouse.Columns)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		if err = json.Unmarshal(UnsafeStr2Bytes(msgBody), &ret); err != nil {
 			return nil, err
@@ -250,12 +696,33 @@ func getMessageBody(v beat.Event) (string, error) {
 	return "", ErrEmptyMessage
 }
 
+// getEventString returns the string value of key in the event fields, or an
+// empty string if it doesn't exist.
+func getEventString(v beat.Event, key string) string {
+	val, err := v.Fields.GetValue(key)
+	if err != nil {
+		return ""
+	}
+	s, _ := val.(string)
+	return s
+}
+
 func getTraceId(msg string) string {
+	return getIdValue(msg, "trace_id")
+}
+
+func getSpanId(msg string) string {
+	return getIdValue(msg, "span_id")
+}
+
+// getIdValue finds the value of name in a json msg ("name":"xxx") or a text msg (name:xxx).
+func getIdValue(msg string, name string) string {
 	var ret string
-	idx := strings.Index(msg, "\"trace_id\":") // for json msg
+	key := "\"" + name + "\":"
+	idx := strings.Index(msg, key) // for json msg
 	if idx >= 0 {
-		idx += 11
-		if msg[idx] == ' ' {
+		idx += len(key)
+		if idx < len(msg) && msg[idx] == ' ' {
 			idx++
 		}
 		ret = msg[idx:]
@@ -266,10 +733,11 @@ func getTraceId(msg string) string {
 			ret = ""
 		}
 	} else {
-		idx = strings.Index(msg, "trace_id:")
+		key = name + ":"
+		idx = strings.Index(msg, key)
 		if idx >= 0 {
-			idx += 9
-			if msg[idx] == ' ' {
+			idx += len(key)
+			if idx < len(msg) && msg[idx] == ' ' {
 				idx++
 			}
 			ret = msg[idx:]
@@ -0,0 +1,347 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares got with the golden file testdata/name.golden, or
+// rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run the test with -update to create it", err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("%s: output differs from %s\ngot:\n%s\nexpected:\n%s", name, path, got, expected)
+	}
+}
+
+func goldenEvent(msg string) beat.Event {
+	return beat.Event{
+		Timestamp: time.Date(2024, 5, 1, 12, 30, 45, 123000000, time.UTC),
+		Fields: mapstr.M{
+			"message":   msg,
+			"host.name": "web-1",
+			"log":       mapstr.M{"file": mapstr.M{"path": "/var/log/app.log"}},
+		},
+	}
+}
+
+func TestMakeEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings clientSettings
+		msg      string
+	}{
+		{"shushu", clientSettings{Channel: channelShushu, AppId: "app"}, `{"#type":"track","#event_name":"login","#account_id":"1"}`},
+		{"shushu_event_ids", clientSettings{Channel: channelShushu, AppId: "app", Idempotency: idempotencyConfig{EventIDs: true}}, `{"#type":"track","#account_id":"1"}`},
+		{"openobserve", clientSettings{Channel: channelOpenObserve}, `GET /index.html 200 "curl/8.0"`},
+		{"openobserve_trace_json", clientSettings{Channel: channelOpenObserve}, `{"level":"info","trace_id": "4bf92f3577b34da6","msg":"ok"}`},
+		{"openobserve_trace_text", clientSettings{Channel: channelOpenObserve}, `INFO trace_id:4bf92f3577b34da6 request done`},
+		{"sa", clientSettings{Channel: channelSa}, `{"type":"track","event":"login","distinct_id":"1"}`},
+		{"webhook", clientSettings{Channel: channelWebhook}, `{"text":"disk full"}`},
+		{"splunk_hec", clientSettings{Channel: channelSplunkHEC, SplunkHEC: hecConfig{Sourcetype: "app", Index: "main"}}, `ERROR connection refused`},
+		{"es_bulk", clientSettings{Channel: channelESBulk}, `{"level":"warn","msg":"slow query"}`},
+		{"datadog", clientSettings{Channel: channelDatadog, Datadog: datadogConfig{Service: "api", Tags: []string{"env:prod"}}}, `ERROR connection refused`},
+		{"newrelic", clientSettings{Channel: channelNewRelic}, `WARN trace_id=1 slow`},
+		{"default", clientSettings{}, `{"b":2,"a":[1,"x"]}`},
+	}
+
+	for _, test := range tests {
+		e, err := makeEvent(goldenEvent(test.msg), &test.settings)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		// the keys of eventRaw are sorted by json.Marshal
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkGolden(t, "makeEvent/"+test.name, append(b, '\n'))
+	}
+
+	if _, err := makeEvent(goldenEvent(""), &clientSettings{Channel: channelOpenObserve}); err != ErrEmptyMessage {
+		t.Errorf("expected %v for an empty message, got %v", ErrEmptyMessage, err)
+	}
+}
+
+func TestGetTraceId(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{`{"trace_id":"abc","msg":"x"}`, "abc"},
+		{`{"msg":"x", "trace_id": "abc"}`, "abc"},
+		{`{"trace_id":123}`, "123"},
+		{`trace_id:abc done`, "abc"},
+		{`trace_id: abc`, "abc"},
+		{`trace_id:"abc"	done`, "abc"},
+		{`no trace here`, ""},
+		{`{"trace_id":"abc"`, ""},
+		{`{"trace_id":"a:b"}`, ""},
+		{`trace_id:` + strings.Repeat("a", 65), ""},
+		// the key at the end of the message
+		{`{"trace_id":`, ""},
+		{`trace_id:`, ""},
+		{`"trace_id":`, ""},
+	}
+
+	for _, test := range tests {
+		if got := getTraceId(test.msg); got != test.expected {
+			t.Errorf("getTraceId(%q): expected %q, got %q", test.msg, test.expected, got)
+		}
+	}
+	if got := getSpanId(`{"trace_id":"abc","span_id":"def"}`); got != "def" {
+		t.Errorf("expected span id def, got %q", got)
+	}
+}
+
+func FuzzGetTraceId(f *testing.F) {
+	for _, seed := range []string{
+		`{"trace_id":"4bf92f3577b34da6","msg":"ok"}`,
+		`INFO trace_id: 4bf92f3577b34da6 done`,
+		`{"trace_id":`,
+		`trace_id:`,
+		`trace_id`,
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		id := getTraceId(msg)
+		if len(id) > 64 || strings.Contains(id, ":") {
+			t.Fatalf("getTraceId(%q) = %q", msg, id)
+		}
+		if id != "" && !strings.Contains(msg, id) {
+			t.Fatalf("getTraceId(%q) = %q is not part of the message", msg, id)
+		}
+	})
+}
+
+func FuzzMakeEvent(f *testing.F) {
+	for _, seed := range []string{
+		`{"#type":"track","#account_id":"1"}`,
+		`{"level":"info","trace_id": "4bf92f3577b34da6"}`,
+		`INFO trace_id:a\x"b done`,
+		`{"trace_id":`,
+		`plain text`,
+	} {
+		f.Add(seed)
+	}
+
+	channels := []clientSettings{
+		{Channel: channelOpenObserve},
+		{Channel: channelShushu, AppId: "app", Idempotency: idempotencyConfig{EventIDs: true}},
+		{Channel: channelSa},
+		{Channel: channelSplunkHEC},
+		{Channel: channelESBulk},
+		{Channel: channelNewRelic},
+		{},
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		for i := range channels {
+			e, err := makeEvent(goldenEvent(msg), &channels[i])
+			if err != nil {
+				continue
+			}
+			if channels[i].Channel != channelOpenObserve {
+				continue
+			}
+
+			// openobserve events are built from the message, they must
+			// always be valid json
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatalf("invalid event of %q: %v", msg, err)
+			}
+			var decoded struct {
+				Log string `json:"log"`
+			}
+			if err = json.Unmarshal(b, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if utf8.ValidString(msg) && decoded.Log != msg {
+				t.Fatalf("expected log %q, got %q", msg, decoded.Log)
+			}
+		}
+	})
+}
+
+func TestPartialSuccess(t *testing.T) {
+	tests := []struct {
+		channel  string
+		status   int
+		response string
+		retry    []string
+		err      error
+		// format of the messages, test %d by default
+		format string
+		// settings added to the output config
+		settings map[string]any
+		// the events are acked without rejected events
+		accepted bool
+	}{
+		{
+			// the events before the invalid one are indexed, the ones after it are retried
+			channel:  channelSplunkHEC,
+			status:   http.StatusBadRequest,
+			response: `{"text":"Invalid data format","code":6,"invalid-event-number":1}`,
+			retry:    []string{"test 3", "test 4"},
+			err:      errPartialSuccess,
+		},
+		{
+			channel:  channelSplunkHEC,
+			status:   http.StatusBadRequest,
+			response: `{"text":"Invalid data format","code":6,"invalid-event-number":3}`,
+		},
+		{
+			channel:  channelSplunkHEC,
+			status:   http.StatusServiceUnavailable,
+			response: `{"text":"Server is busy","code":9}`,
+			retry:    []string{"test 1", "test 2", "test 3", "test 4"},
+		},
+		{
+			// the failed records are dropped by number
+			channel:  channelOpenObserve,
+			status:   http.StatusOK,
+			response: `{"code":200,"status":[{"name":"default","successful":2,"failed":2,"error":"schema mismatch"}]}`,
+		},
+		{
+			// thinkingdata answers 200 with a code, invalid data is dropped
+			channel:  channelShushu,
+			status:   http.StatusOK,
+			response: `{"code":-1,"msg":"invalid data"}`,
+			format:   `{"#type":"track","#account_id":"%d"}`,
+		},
+		{
+			channel:  channelShushu,
+			status:   http.StatusOK,
+			response: `{"code":-5,"msg":"server busy"}`,
+			format:   `{"#type":"track","#account_id":"%d"}`,
+			retry:    []string{`{"#type":"track","#account_id":"1"}`, `{"#type":"track","#account_id":"2"}`, `{"#type":"track","#account_id":"3"}`, `{"#type":"track","#account_id":"4"}`},
+		},
+		{
+			channel:  channelShushu,
+			status:   http.StatusOK,
+			response: `<html>bad gateway</html>`,
+			format:   `{"#type":"track","#account_id":"%d"}`,
+			accepted: true,
+		},
+		{
+			channel:  channelSa,
+			status:   http.StatusOK,
+			response: `{"code":1,"message":"invalid record"}`,
+			format:   `{"type":"track","distinct_id":"%d"}`,
+		},
+		{
+			// created, duplicate with op_type create, retried and rejected items
+			channel:  channelESBulk,
+			status:   http.StatusOK,
+			response: `{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":409}},{"create":{"status":429}},{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`,
+			retry:    []string{"test 3"},
+			err:      errPartialSuccess,
+			settings: map[string]any{"es_bulk.op_type": "create"},
+		},
+		{
+			// a conflict of op_type index is rejected
+			channel:  channelESBulk,
+			status:   http.StatusOK,
+			response: `{"errors":true,"items":[{"index":{"status":409}},{"index":{"status":503}},{"index":{"status":200}},{"index":{"status":500}}]}`,
+			retry:    []string{"test 2", "test 4"},
+			err:      errPartialSuccess,
+		},
+	}
+
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, test.response)
+		}))
+
+		settings := map[string]any{
+			"hosts":            []string{strings.TrimPrefix(srv.URL, "http://")},
+			"channel":          test.channel,
+			"batch_mode":       true,
+			"splunk_hec.token": "token",
+			"backoff.init":     "1ms",
+			"backoff.max":      "1ms",
+		}
+		for key, val := range test.settings {
+			settings[key] = val
+		}
+		output := newTestOutput(t, settings)
+
+		events := make([]beat.Event, 4)
+		for i := range events {
+			format := test.format
+			if format == "" {
+				format = "test %d"
+			}
+			events[i] = beat.Event{Fields: mapstr.M{"message": fmt.Sprintf(format, i+1)}}
+		}
+		batch := outest.NewBatch(events...)
+		err := output.Publish(context.Background(), batch)
+		srv.Close()
+
+		cli := output.(*client)
+		if rejected := cli.settings.Drops.counters[dropRejected].Get(); len(test.retry) == 0 && test.err == nil && (rejected == 0) != test.accepted {
+			t.Errorf("%s %s: unexpected %d rejected events", test.channel, test.response, rejected)
+		}
+
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s %s: expected error %v, got %v", test.channel, test.response, test.err, err)
+		}
+		if len(batch.Signals) != 1 {
+			t.Fatalf("%s %s: unexpected signals %v", test.channel, test.response, batch.Signals)
+		}
+		signal := batch.Signals[0]
+		if len(test.retry) == 0 {
+			if signal.Tag != outest.BatchACK {
+				t.Errorf("%s %s: expected the batch to be acked, got %v", test.channel, test.response, signal)
+			}
+			continue
+		}
+
+		var retry []string
+		for _, e := range signal.Events {
+			msg, _ := e.Content.Fields.GetValue("message")
+			retry = append(retry, msg.(string))
+		}
+		if signal.Tag != outest.BatchRetryEvents || strings.Join(retry, ",") != strings.Join(test.retry, ",") {
+			t.Errorf("%s %s: expected retry of %v, got %v %v", test.channel, test.response, test.retry, signal.Tag, retry)
+		}
+	}
+}
@@ -28,46 +28,190 @@ const (
 	loggerName = "httpout"
 
 	idleConnectTimeout = 1 * time.Minute
-	maxRetries         = 3
-	backoffInit        = 1 * time.Second
-	backoffMax         = 60 * time.Second
 
 	channelShushu      = "shushu"
 	channelOpenObserve = "openobserve"
 	channelSa          = "sa"
+	channelSplunkHEC   = "splunk_hec"
+	channelESBulk      = "es_bulk"
+	channelOTLP        = "otlp"
+	channelDatadog     = "datadog"
+	channelNewRelic    = "newrelic"
+	channelClickHouse  = "clickhouse"
+	channelWebhook     = "webhook"
 )
 
 var (
 	ErrNotConnected = errors.New("not connected")         // failure due to client having no valid connection
 	ErrEncodeFailed = errors.New("message encode failed") // encoding failures
 	ErrEmptyMessage = errors.New("empty message")
+	ErrCircuitOpen  = errors.New("circuit breaker open") // the host is failing, sending is paused
 )
 
 type httpConfig struct {
 	Protocol         string            `config:"protocol"`
 	Path             string            `config:"path"`
 	Headers          map[string]string `config:"headers"`
+	HeaderFiles      map[string]string `config:"header_files"`
+	ParamFiles       map[string]string `config:"param_files"`
 	Username         string            `config:"username"`
 	Password         string            `config:"password"`
+	PasswordFile     string            `config:"password_file"`
+	BearerToken      string            `config:"bearer_token"`
+	BearerTokenFile  string            `config:"bearer_token_file"`
+	OAuth2           *oauth2Config     `config:"oauth2"`
 	LoadBalance      bool              `config:"loadbalance"`
 	CompressionLevel int               `config:"compression_level" validate:"min=0, max=9"`
 	BulkMaxSize      int               `config:"bulk_max_size"`
+	MaxRetries       int               `config:"max_retries" validate:"min=-1"`
+	RetryStatuses    []int             `config:"retry_statuses"`
+	Backoff          backoffConfig     `config:"backoff"`
+	AttemptTimeout   time.Duration     `config:"attempt_timeout" validate:"min=0"`
 	BatchMode        bool              `config:"batch_mode"`
+	BatchMaxEvents   int               `config:"batch_max_events" validate:"min=0"`
+	BatchMaxBytes    int               `config:"batch_max_bytes" validate:"min=0"`
 	Channel          string            `config:"channel"`
 	AppId            string            `config:"app_id"`
+	AppIdFile        string            `config:"app_id_file"`
+	SplunkHEC        hecConfig         `config:"splunk_hec"`
+	Bulk             bulkConfig        `config:"es_bulk"`
+	OTLP             otlpConfig        `config:"otlp"`
+	Datadog          datadogConfig     `config:"datadog"`
+	NewRelic         newRelicConfig    `config:"newrelic"`
+	ClickHouse       clickHouseConfig  `config:"clickhouse"`
+	Webhook          webhookConfig     `config:"webhook"`
+	Signer           signerConfig      `config:"signer"`
+	Idempotency      idempotencyConfig `config:"idempotency"`
+	RateLimit        rateLimitConfig   `config:"rate_limit"`
+	Concurrency      concurrencyConfig `config:"concurrency"`
+	Debug            debugConfig       `config:"debug_capture"`
+	Spool            spoolConfig       `config:"spool"`
+	CircuitBreaker   breakerConfig     `config:"circuit_breaker"`
+	Mirror           *config.C         `config:"mirror"`
 	Queue            config.Namespace  `config:"queue"`
+	Balance          balanceConfig     `config:",inline"`
 
 	Transport httpcommon.HTTPTransportSettings `config:",inline"`
 }
 
 func (c *httpConfig) Validate() error {
+	if err := c.validateSecrets(); err != nil {
+		return err
+	}
+
+	tokens := 0
+	for _, set := range []bool{c.BearerToken != "", c.BearerTokenFile != "", c.OAuth2 != nil} {
+		if set {
+			tokens++
+		}
+	}
+	if tokens > 1 {
+		return errors.New("only one of bearer_token, bearer_token_file and oauth2 can be set")
+	}
+	if tokens > 0 && (c.Username != "" || c.Password != "" || c.PasswordFile != "") {
+		return errors.New("username and password can't be used with bearer token auth")
+	}
+	// the sigv4 signature is the Authorization header of the request
+	if c.Signer.Type == signerAWSSigV4 && (tokens > 0 || c.Username != "" || c.Password != "" || c.PasswordFile != "") {
+		return errors.New("signer aws_sigv4 can't be used with username, password, bearer token or oauth2 auth")
+	}
+
+	switch c.Channel {
+	case channelSplunkHEC:
+		if c.SplunkHEC.Token == "" {
+			return errors.New("splunk_hec.token is required for channel splunk_hec")
+		}
+	case channelDatadog:
+		if c.Datadog.APIKey == "" {
+			return errors.New("datadog.api_key is required for channel datadog")
+		}
+	case channelNewRelic:
+		if c.NewRelic.APIKey == "" && c.NewRelic.LicenseKey == "" {
+			return errors.New("newrelic.api_key or newrelic.license_key is required for channel newrelic")
+		}
+	case channelClickHouse:
+		if c.ClickHouse.Table == "" {
+			return errors.New("clickhouse.table is required for channel clickhouse")
+		}
+	case channelWebhook:
+		// the lines are acked when queued, a failed notification isn't retried
+		if c.Spool.Path != "" {
+			return errors.New("spool can't be used with channel webhook")
+		}
+	}
 	return nil
 }
 
+// channelPath returns the configured path, or the default endpoint of the
+// channel when no path is set.
+func (c *httpConfig) channelPath() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	switch c.Channel {
+	case channelSplunkHEC:
+		if c.SplunkHEC.Raw {
+			return hecRawPath
+		}
+		return hecEventPath
+	case channelESBulk:
+		return bulkPath
+	case channelOTLP:
+		return otlpLogsPath
+	case channelDatadog:
+		return datadogLogsPath
+	case channelNewRelic:
+		return newRelicLogsPath
+	}
+	return ""
+}
+
+// batchLimits returns the max events and bytes of one request, the intake
+// apis of datadog and new relic reject larger requests. 0 means no limit.
+func (c *httpConfig) batchLimits() (int, int) {
+	maxEvents, maxBytes := c.BatchMaxEvents, c.BatchMaxBytes
+	switch c.Channel {
+	case channelDatadog:
+		maxEvents = limitOrDefault(maxEvents, datadogMaxBatchEvents)
+		maxBytes = limitOrDefault(maxBytes, datadogMaxBatchBytes)
+	case channelNewRelic:
+		maxEvents = limitOrDefault(maxEvents, newRelicMaxBatchEvents)
+		maxBytes = limitOrDefault(maxBytes, newRelicMaxBatchBytes)
+	}
+	return maxEvents, maxBytes
+}
+
+func limitOrDefault(limit, channelLimit int) int {
+	if limit == 0 || limit > channelLimit {
+		return channelLimit
+	}
+	return limit
+}
+
 var (
 	defaultConfig = httpConfig{
 		BulkMaxSize: 50,
+		MaxRetries:  3,
+		Backoff:     defaultBackoffConfig,
 		LoadBalance: true,
-		Transport:   httpcommon.DefaultHTTPTransportSettings(),
+		SplunkHEC: hecConfig{
+			AckInterval: 1 * time.Second,
+			AckTimeout:  30 * time.Second,
+		},
+		OTLP: otlpConfig{
+			Encoding: otlpEncodingProtobuf,
+		},
+		Signer:         defaultSignerConfig,
+		Concurrency:    defaultConcurrencyConfig,
+		Debug:          defaultDebugConfig,
+		Spool:          defaultSpoolConfig,
+		CircuitBreaker: defaultBreakerConfig,
+		Balance:        defaultBalanceConfig,
+		Webhook: webhookConfig{
+			Format:   webhookSlack,
+			Window:   10 * time.Second,
+			MaxLines: 20,
+		},
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
 	}
 )
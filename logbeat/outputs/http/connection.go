@@ -1,6 +1,9 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
@@ -8,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Connection struct {
@@ -18,6 +22,18 @@ type Connection struct {
 	username string
 	password string
 	headers  map[string]string
+	secrets  *secretFiles
+	token    tokenSource
+	signer   requestSigner
+	timeout  time.Duration
+	stats    *hostStats
+	debug    *debugCapture
+
+	// lastRequest is the last data request, its body can be spooled
+	lastRequest *http.Request
+	// idempotencyKey is sent in idempotencyHeader with the data requests
+	idempotencyHeader string
+	idempotencyKey    string
 }
 
 func NewConnection(s *clientSettings) (*Connection, error) {
@@ -37,11 +53,26 @@ func NewConnection(s *clientSettings) (*Connection, error) {
 		s.URL = u.String()
 	}
 
-	log.Infof("url: %s", s.URL)
+	log.Infof("url: %s", maskURL(s.URL))
 
 	var encoder bodyEncoder
 	if s.Channel == channelSa {
 		encoder = newSaEncoder(nil)
+	} else if s.Channel == channelSplunkHEC {
+		encoder, err = newHECEncoder(s.SplunkHEC.Raw, s.CompressionLevel, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.Channel == channelNewRelic {
+		encoder, err = newNewRelicEncoder(s.NewRelic.common(s.Beat), s.CompressionLevel, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.Channel == channelOTLP {
+		encoder, err = newOTLPEncoder(&s.OTLP, s.Beat, s.CompressionLevel, nil)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		compression := s.CompressionLevel
 		if compression == 0 {
@@ -54,6 +85,11 @@ func NewConnection(s *clientSettings) (*Connection, error) {
 		}
 	}
 
+	signer, err := newRequestSigner(&s.Signer)
+	if err != nil {
+		return nil, err
+	}
+
 	httpClient, err := s.Transport.Client(
 		httpcommon.WithLogger(log),
 		httpcommon.WithIOStats(s.Observer),
@@ -71,6 +107,13 @@ func NewConnection(s *clientSettings) (*Connection, error) {
 		username: s.Username,
 		password: s.Password,
 		headers:  s.Headers,
+		secrets:  newSecretFiles(s),
+		token:    newTokenSource(s, httpClient),
+		signer:   signer,
+		timeout:  s.AttemptTimeout,
+		debug:    s.Debug,
+
+		idempotencyHeader: s.Idempotency.Header,
 	}, nil
 }
 
@@ -119,6 +162,12 @@ func (conn *Connection) execRequest(
 	method, url string,
 	body io.Reader,
 ) (int, []byte, error) {
+	url, err := conn.secrets.addParams(url)
+	if err != nil {
+		conn.log.Warnf("Failed to read url params %+v", err)
+		return 0, nil, err
+	}
+
 	req, err := http.NewRequest(method, url, body) //nolint:noctx // keep legacy behaviour
 	if err != nil {
 		conn.log.Warnf("Failed to create request %+v", err)
@@ -127,14 +176,59 @@ func (conn *Connection) execRequest(
 	if body != nil {
 		conn.encoder.AddHeader(&req.Header)
 	}
+	if conn.idempotencyKey != "" {
+		req.Header.Set(conn.idempotencyHeader, conn.idempotencyKey)
+	}
+	conn.lastRequest = req
+	return conn.execHTTPRequest(req)
+}
+
+// RequestRaw sends an encoded body with its content headers, e.g. replayed
+// from the spool.
+func (conn *Connection) RequestRaw(
+	method, url string,
+	body []byte,
+	header http.Header,
+) (int, []byte, error) {
+	url, err := conn.secrets.addParams(url)
+	if err != nil {
+		conn.log.Warnf("Failed to read url params %+v", err)
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body)) //nolint:noctx // keep legacy behaviour
+	if err != nil {
+		conn.log.Warnf("Failed to create request %+v", err)
+		return 0, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
 	return conn.execHTTPRequest(req)
 }
 
 func (conn *Connection) execHTTPRequest(req *http.Request) (int, []byte, error) {
 	req.Header.Add("Accept", "application/json, */*")
 
-	if conn.username != "" || conn.password != "" {
-		req.SetBasicAuth(conn.username, conn.password)
+	password := conn.password
+	if conn.secrets.password != nil {
+		var err error
+		if password, err = conn.secrets.password.Get(); err != nil {
+			conn.log.Warnf("Failed to read password %+v", err)
+			return 0, nil, err
+		}
+	}
+	if conn.username != "" || password != "" {
+		req.SetBasicAuth(conn.username, password)
+	}
+
+	if conn.token != nil {
+		token, err := conn.token.Token()
+		if err != nil {
+			conn.log.Warnf("Failed to get bearer token %+v", err)
+			return 0, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	for name, value := range conn.headers {
@@ -144,6 +238,14 @@ func (conn *Connection) execHTTPRequest(req *http.Request) (int, []byte, error)
 			req.Header.Add(name, value)
 		}
 	}
+	for name, file := range conn.secrets.headers {
+		value, err := file.Get()
+		if err != nil {
+			conn.log.Warnf("Failed to read header %s %+v", name, err)
+			return 0, nil, err
+		}
+		req.Header.Set(name, value)
+	}
 
 	// The stlib will override the value in the header based on the configured `Host`
 	// on the request which default to the current machine.
@@ -153,14 +255,48 @@ func (conn *Connection) execHTTPRequest(req *http.Request) (int, []byte, error)
 		req.Host = host
 	}
 
+	// The signature covers the final body and headers, so it is the last step.
+	if conn.signer != nil {
+		body, err := requestBody(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err = conn.signer.Sign(req, body); err != nil {
+			conn.log.Warnf("Failed to sign request %+v", err)
+			return 0, nil, err
+		}
+	}
+
+	if conn.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), conn.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	var exchange *debugExchange
+	if conn.debug.sample() {
+		exchange = conn.debug.start(req, conn.secrets)
+	}
+
+	start := time.Now()
 	resp, err := conn.http.Do(req)
 	if err != nil {
+		conn.stats.request(0, time.Since(start), req.ContentLength)
+		if exchange != nil {
+			conn.debug.finish(exchange, nil, nil, err)
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// the error is logged by the pipeline, hide the secrets in the url
+			urlErr.URL = maskURL(urlErr.URL)
+		}
 		return 0, nil, err
 	}
 	defer closing(resp.Body, conn.log)
 
 	status := resp.StatusCode
 	obj, err := io.ReadAll(resp.Body)
+	conn.stats.request(status, time.Since(start), req.ContentLength)
 	if err != nil {
 		return status, nil, err
 	}
@@ -169,6 +305,9 @@ func (conn *Connection) execHTTPRequest(req *http.Request) (int, []byte, error)
 		// add the response body with the error returned by Elasticsearch
 		err = fmt.Errorf("%v: %s", resp.Status, obj)
 	}
+	if exchange != nil {
+		conn.debug.finish(exchange, resp, obj, err)
+	}
 
 	return status, obj, err
 }
@@ -188,12 +327,30 @@ func addToURL(strUrl string, path string, params map[string]string) string {
 		return strUrl + path
 	}
 
+	sep := "?"
+	if strings.Contains(strUrl+path, "?") {
+		sep = "&"
+	}
+
 	values := url.Values{}
 	for key, val := range params {
 		values.Add(key, val)
 	}
 
 	return strings.Join([]string{
-		strUrl, path, "?", values.Encode(),
+		strUrl, path, sep, values.Encode(),
 	}, "")
 }
+
+// mergeHeaders returns a copy of headers with extra added, the configured
+// headers map is shared by all clients and must not be modified.
+func mergeHeaders(headers map[string]string, extra map[string]string) map[string]string {
+	ret := make(map[string]string, len(headers)+len(extra))
+	for name, value := range headers {
+		ret[name] = value
+	}
+	for name, value := range extra {
+		ret[name] = value
+	}
+	return ret
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// datadog referrer: https://docs.datadoghq.com/api/latest/logs/#send-logs
+
+const (
+	datadogLogsPath = "/api/v2/logs"
+
+	datadogMaxBatchEvents = 1000
+	datadogMaxBatchBytes  = 5 * 1024 * 1024
+)
+
+type datadogConfig struct {
+	APIKey   string   `config:"api_key"`
+	Source   string   `config:"source"`
+	Service  string   `config:"service"`
+	Hostname string   `config:"hostname"`
+	Tags     []string `config:"tags"`
+}
+
+func (c *datadogConfig) headers(headers map[string]string) map[string]string {
+	return mergeHeaders(headers, map[string]string{"DD-API-KEY": c.APIKey})
+}
+
+// makeDatadogEvent: {"ddsource":"xxx","ddtags":"k:v,k:v","hostname":"xxx","message":"xxx","service":"xxx"}
+func makeDatadogEvent(v beat.Event, msg string, conf *datadogConfig, hostname string) eventRaw {
+	ret := make(eventRaw)
+	ret["message"] = rawString(msg)
+	ret["timestamp"] = rawString(v.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"))
+
+	source := conf.Source
+	if source == "" {
+		source = "logbeat"
+	}
+	ret["ddsource"] = rawString(source)
+
+	host := conf.Hostname
+	if host == "" {
+		host = getEventString(v, "host.name")
+		if host == "" {
+			host = hostname
+		}
+	}
+	if host != "" {
+		ret["hostname"] = rawString(host)
+	}
+	if conf.Service != "" {
+		ret["service"] = rawString(conf.Service)
+	}
+	if len(conf.Tags) > 0 {
+		ret["ddtags"] = rawString(strings.Join(conf.Tags, ","))
+	}
+	if path := getEventString(v, "log.file.path"); path != "" {
+		ret["filepath"] = rawString(path)
+	}
+	if _, level := getSeverity(msg); level != "" {
+		ret["status"] = rawString(strings.ToLower(level))
+	}
+	return ret
+}
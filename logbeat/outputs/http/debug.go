@@ -0,0 +1,213 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/elastic-agent-libs/file"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// redactedHeaders are the request headers always redacted, besides the ones
+// named like a secret.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+type debugConfig struct {
+	Enabled    bool    `config:"enabled"`
+	SampleRate float64 `config:"sample_rate" validate:"min=0, max=1"`
+	MaxBody    int     `config:"max_body_bytes" validate:"min=0"`
+	// File is the path prefix of the capture files, <file>-<date>.ndjson. The
+	// captures are logged when no file is set.
+	File       string `config:"file"`
+	MaxSize    uint   `config:"max_size"`
+	MaxBackups uint   `config:"max_backups"`
+}
+
+var defaultDebugConfig = debugConfig{
+	SampleRate: 1,
+	MaxBody:    4096,
+	MaxSize:    10 * 1024 * 1024,
+	MaxBackups: 3,
+}
+
+// debugCapture writes a sample of the requests and responses of the output,
+// one json object per line.
+type debugCapture struct {
+	conf   *debugConfig
+	log    *logp.Logger
+	writer *file.Rotator
+}
+
+// newDebugCapture returns nil when the capture is disabled.
+func newDebugCapture(conf *debugConfig) (*debugCapture, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	d := &debugCapture{
+		conf: conf,
+		log:  logp.NewLogger(loggerName + ".debug"),
+	}
+	if conf.File != "" {
+		rotator, err := file.NewFileRotator(conf.File,
+			file.MaxSizeBytes(conf.MaxSize),
+			file.MaxBackups(conf.MaxBackups),
+			file.Permissions(0600),
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.writer = rotator
+	}
+	return d, nil
+}
+
+type debugExchange struct {
+	Time     time.Time      `json:"time"`
+	Method   string         `json:"method"`
+	URL      string         `json:"url"`
+	Duration float64        `json:"duration_ms"`
+	Request  debugMessage   `json:"request"`
+	Response *debugResponse `json:"response,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type debugMessage struct {
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body,omitempty"`
+	Truncated bool                `json:"truncated,omitempty"`
+}
+
+type debugResponse struct {
+	Status int `json:"status"`
+	debugMessage
+}
+
+// sample reports whether the next request is captured.
+func (d *debugCapture) sample() bool {
+	return d != nil && rand.Float64() < d.conf.SampleRate
+}
+
+// start captures the request, it must be called once the request is final.
+func (d *debugCapture) start(req *http.Request, secrets *secretFiles) *debugExchange {
+	ex := &debugExchange{
+		Time:   time.Now(),
+		Method: req.Method,
+		URL:    secrets.maskURL(req.URL.String()),
+	}
+	ex.Request.Headers = redactHeaders(req.Header, secrets.isSecretHeader)
+
+	body, err := requestBody(req)
+	if err != nil {
+		ex.Request.Body = "<" + err.Error() + ">"
+	} else {
+		d.setBody(&ex.Request, body, req.Header.Get("Content-Encoding"))
+	}
+	return ex
+}
+
+// finish writes the exchange with its response, resp is nil on transport errors.
+func (d *debugCapture) finish(ex *debugExchange, resp *http.Response, body []byte, err error) {
+	ex.Duration = float64(time.Since(ex.Time).Microseconds()) / 1000
+	if resp != nil {
+		ex.Response = &debugResponse{Status: resp.StatusCode}
+		ex.Response.Headers = redactHeaders(resp.Header, nil)
+		// the transport decompressed the body unless it was requested
+		d.setBody(&ex.Response.debugMessage, body, "")
+	}
+	if err != nil {
+		ex.Error = err.Error()
+	}
+
+	line, err := json.Marshal(ex)
+	if err != nil {
+		d.log.Warnf("Failed to encode debug capture: %v", err)
+		return
+	}
+
+	if d.writer == nil {
+		d.log.Info(string(line))
+		return
+	}
+
+	if _, err = d.writer.Write(append(line, '\n')); err != nil {
+		d.log.Warnf("Failed to write debug capture: %v", err)
+	}
+}
+
+// setBody sets the decoded body truncated to max_body_bytes, the bodies which
+// are not text are base64 encoded.
+func (d *debugCapture) setBody(msg *debugMessage, body []byte, encoding string) {
+	if strings.EqualFold(encoding, "gzip") && len(body) > 0 {
+		decoded, err := gunzip(body, d.conf.MaxBody)
+		if err != nil {
+			msg.Body = "<" + err.Error() + ">"
+			return
+		}
+		body = decoded
+	}
+
+	if len(body) > d.conf.MaxBody {
+		body = body[:d.conf.MaxBody]
+		msg.Truncated = true
+		// don't split the last character
+		for i := 0; i < utf8.UTFMax-1 && len(body) > 0 && !utf8.Valid(body); i++ {
+			body = body[:len(body)-1]
+		}
+	}
+	if utf8.Valid(body) {
+		msg.Body = string(body)
+	} else {
+		msg.Body = "base64:" + base64.StdEncoding.EncodeToString(body)
+	}
+}
+
+// gunzip decompresses one byte more than limit, to detect the truncation.
+func gunzip(body []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// close closes the capture file, which is opened again by the next capture of
+// another client.
+func (d *debugCapture) close() error {
+	if d == nil || d.writer == nil {
+		return nil
+	}
+	return d.writer.Close()
+}
+
+func redactHeaders(header http.Header, isSecret func(name string) bool) map[string][]string {
+	ret := make(map[string][]string, len(header))
+	for name, values := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] || isSecretKey(name) || (isSecret != nil && isSecret(name)) {
+			ret[name] = []string{maskedValue}
+			continue
+		}
+		ret[name] = values
+	}
+	return ret
+}
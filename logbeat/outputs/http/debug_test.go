@@ -0,0 +1,97 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugCapture(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		fmt.Fprint(w, `{"code":200,"status":[{"name":"default","successful":3,"failed":0}]}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "param"), "p1")
+	output := newTestOutput(t, map[string]any{
+		"hosts":                        []string{strings.TrimPrefix(srv.URL, "http://")},
+		"path":                         "/api?sign=s1&org=default",
+		"channel":                      "openobserve",
+		"batch_mode":                   true,
+		"username":                     "user",
+		"password":                     "secret",
+		"compression_level":            5,
+		"headers":                      map[string]any{"X-Api-Key": "k1", "X-Scope": "logs"},
+		"param_files":                  map[string]any{"tenant": filepath.Join(dir, "param")},
+		"debug_capture.enabled":        true,
+		"debug_capture.max_body_bytes": 20,
+		"debug_capture.file":           filepath.Join(dir, "http-debug"),
+	})
+	if err := sendTestEvents(output, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "http-debug*.ndjson"))
+	if len(files) != 1 {
+		t.Fatalf("expected a capture file, got %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret", "s1", "k1", "p1", "abc", "dXNlcjpzZWNyZXQ"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("secret %q captured: %s", secret, data)
+		}
+	}
+
+	var ex debugExchange
+	if err = json.Unmarshal(data, &ex); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ex.URL, "org=default") || ex.Request.Headers["X-Scope"][0] != "logs" ||
+		ex.Request.Headers["Authorization"][0] != maskedValue || ex.Request.Headers["X-Api-Key"][0] != maskedValue {
+		t.Errorf("unexpected request %+v", ex)
+	}
+	// the gzip body is decoded and truncated
+	if !ex.Request.Truncated || len(ex.Request.Body) != 20 || !strings.HasPrefix(ex.Request.Body, `[{"`) {
+		t.Errorf("unexpected request body %q", ex.Request.Body)
+	}
+	if ex.Response == nil || ex.Response.Status != http.StatusOK || ex.Response.Headers["Set-Cookie"][0] != maskedValue {
+		t.Errorf("unexpected response %+v", ex.Response)
+	}
+}
+
+func TestDebugSample(t *testing.T) {
+	var d *debugCapture
+	if d.sample() {
+		t.Fatal("expected a disabled capture to sample nothing")
+	}
+
+	for _, test := range []struct {
+		rate     float64
+		min, max int
+	}{
+		{0, 0, 0},
+		{0.5, 400, 600},
+		{1, 1000, 1000},
+	} {
+		d = &debugCapture{conf: &debugConfig{SampleRate: test.rate}}
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if d.sample() {
+				n++
+			}
+		}
+		if n < test.min || n > test.max {
+			t.Errorf("sample_rate %v: expected %d to %d captures, got %d", test.rate, test.min, test.max, n)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// destinationConfig are the settings of a named destination. A destination
+// doesn't inherit the settings of the http output, its hosts, channel,
+// bulk_max_size, max_retries and so on are set in its own block. The
+// bulk_max_size and max_retries of the output only apply to the batches of the
+// pipeline split between the destinations.
+type destinationConfig struct {
+	Name string `config:"name" validate:"required"`
+	// When selects the events sent to the destination, all events by default.
+	When *conditions.Config `config:"when"`
+	// QueueSize is the number of batches buffered for the destination. The
+	// destinations share the pipeline queue of the output, they have no
+	// queue settings of their own.
+	QueueSize int       `config:"queue_size" validate:"min=1"`
+	Queue     *config.C `config:"queue"`
+}
+
+var defaultDestinationConfig = destinationConfig{
+	QueueSize: 4,
+}
+
+func (c *destinationConfig) Validate() error {
+	// the name is a registry name of the metrics
+	if strings.Contains(c.Name, ".") {
+		return fmt.Errorf("invalid destination name %s, dots are not allowed", c.Name)
+	}
+	if c.Queue != nil {
+		return fmt.Errorf("destination %s: queue is not supported, use queue_size", c.Name)
+	}
+	return nil
+}
+
+// makeDestinations creates the output sending the events to the destination
+// of the first route they match, or without routes to all destinations whose
+// condition they match. Every destination has its own hosts, workers and
+// buffer of queue_size batches, so a slow or failing destination doesn't hold
+// back the others until its buffer is full. A batch is acked when all destinations published
+// or dropped their events.
+func makeDestinations(
+	conf *httpConfig,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *config.C,
+	reg *monitoring.Registry,
+	log *logp.Logger,
+) (outputs.Group, error) {
+	var settings struct {
+		Destinations []*config.C   `config:"destinations" validate:"required"`
+		Routes       []routeConfig `config:"routes"`
+	}
+	if err := cfg.Unpack(&settings); err != nil {
+		return outputs.Fail(err)
+	}
+
+	router := &destinationRouter{
+		observer: observer,
+		log:      log,
+		unrouted: monitoring.NewInt(reg, "dropped.no_destination"),
+	}
+	names := make(map[string]bool)
+	for _, destCfg := range settings.Destinations {
+		d, err := newDestination(destCfg, beat, destinationObserver{observer}, subRegistry(reg, "destinations"), log)
+		if err != nil {
+			_ = router.Close()
+			return outputs.Fail(err)
+		}
+		router.destinations = append(router.destinations, d)
+		if names[d.name] {
+			_ = router.Close()
+			return outputs.Fail(fmt.Errorf("duplicate destination name %s", d.name))
+		}
+		names[d.name] = true
+	}
+	routes, err := newRoutes(settings.Routes, router.destinations)
+	if err != nil {
+		_ = router.Close()
+		return outputs.Fail(err)
+	}
+	router.routes = routes
+	router.start()
+
+	return outputs.Success(conf.BulkMaxSize, conf.MaxRetries, router)
+}
+
+// destination is a named output of the http output.
+type destination struct {
+	name      string
+	cond      conditions.Condition
+	retry     int
+	batchSize int
+	clients   []outputs.NetworkClient
+	queue     *destinationQueue
+	log       *logp.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	routed  *monitoring.Int
+	acked   *monitoring.Int
+	dropped *monitoring.Int
+	skipped *monitoring.Int
+}
+
+func newDestination(
+	cfg *config.C,
+	beat beat.Info,
+	observer outputs.Observer,
+	reg *monitoring.Registry,
+	log *logp.Logger,
+) (*destination, error) {
+	destConf := defaultDestinationConfig
+	if err := cfg.Unpack(&destConf); err != nil {
+		return nil, err
+	}
+	conf := defaultConfig
+	if err := cfg.Unpack(&conf); err != nil {
+		return nil, fmt.Errorf("destination %s: %w", destConf.Name, err)
+	}
+
+	var cond conditions.Condition
+	if destConf.When != nil {
+		var err error
+		if cond, err = conditions.NewCondition(destConf.When); err != nil {
+			return nil, fmt.Errorf("destination %s: %w", destConf.Name, err)
+		}
+	}
+
+	reg = subRegistry(reg, destConf.Name)
+	clients, err := makeClients(&conf, cfg, beat, observer, reg, log)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s: %w", destConf.Name, err)
+	}
+	if !conf.loadBalanced() {
+		clients = []outputs.NetworkClient{outputs.NewFailoverClient(clients)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &destination{
+		name:      destConf.Name,
+		cond:      cond,
+		retry:     conf.MaxRetries,
+		batchSize: conf.BulkMaxSize,
+		clients:   clients,
+		queue:     newDestinationQueue(destConf.QueueSize, monitoring.NewInt(reg, "queue.batches")),
+		log:       log,
+		ctx:       ctx,
+		cancel:    cancel,
+		routed:    monitoring.NewInt(reg, "events.routed"),
+		acked:     monitoring.NewInt(reg, "events.acked"),
+		dropped:   monitoring.NewInt(reg, "events.dropped"),
+		skipped:   monitoring.NewInt(reg, "events.skipped"),
+	}, nil
+}
+
+func (d *destination) match(event *beat.Event) bool {
+	return d.cond == nil || d.cond.Check(event)
+}
+
+// start starts a worker per client.
+func (d *destination) start() {
+	for _, cli := range d.clients {
+		d.wg.Add(1)
+		go d.run(cli)
+	}
+}
+
+// run publishes the batches of the queue with cli, like a libbeat output
+// worker.
+func (d *destination) run(cli outputs.NetworkClient) {
+	defer d.wg.Done()
+
+	connected := false
+	for {
+		b, ok := d.queue.pop()
+		if !ok {
+			return
+		}
+
+		if !connected {
+			if err := cli.Connect(); err != nil {
+				d.log.Errorf("destination %s failed to connect to %v: %v", d.name, cli, err)
+				// another worker may take the batch
+				d.queue.requeue(b)
+				continue
+			}
+			connected = true
+		}
+
+		if err := cli.Publish(d.ctx, b); err != nil {
+			d.log.Errorf("destination %s failed to publish events: %v", d.name, err)
+			connected = false
+		}
+	}
+}
+
+func (d *destination) close() error {
+	d.queue.close()
+	d.cancel()
+	d.wg.Wait()
+
+	var errs []error
+	for _, cli := range d.clients {
+		if err := cli.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// destinationRouter is the output worker of the destinations, it splits every
+// batch into the batches of the destinations.
+type destinationRouter struct {
+	destinations []*destination
+	routes       []route
+	observer     outputs.Observer
+	log          *logp.Logger
+
+	unrouted *monitoring.Int
+}
+
+func (r *destinationRouter) start() {
+	for _, d := range r.destinations {
+		d.start()
+	}
+}
+
+func (r *destinationRouter) Close() error {
+	var errs []error
+	for _, d := range r.destinations {
+		if err := d.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *destinationRouter) String() string {
+	return "httpout(destinations)"
+}
+
+func (r *destinationRouter) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	r.observer.NewBatch(len(events))
+
+	groups, unrouted := r.route(events)
+
+	var batches []*destinationBatch
+	routed := &routedBatch{batch: batch, observer: r.observer, events: len(events)}
+	for i, d := range r.destinations {
+		batches = append(batches, d.split(routed, groups[i])...)
+	}
+
+	// the batch is acked when the last destination batch is done, not before
+	// all of them are queued
+	routed.pending.Store(int32(len(batches)) + 1)
+	for _, b := range batches {
+		if !b.dest.queue.push(b) {
+			// the destinations are closed, the pipeline sends the batch again
+			// after the output is reloaded. The batches already queued are
+			// never done, so the batch is not acked.
+			r.observer.Cancelled(len(events))
+			batch.Cancelled()
+			return ErrNotConnected
+		}
+	}
+	for i, d := range r.destinations {
+		d.routed.Add(int64(len(groups[i])))
+	}
+	if unrouted > 0 {
+		r.unrouted.Add(int64(unrouted))
+		routed.dropped.Add(int32(unrouted))
+	}
+	routed.done()
+	return nil
+}
+
+// split returns the batches of events, of at most bulk_max_size events.
+func (d *destination) split(routed *routedBatch, events []publisher.Event) []*destinationBatch {
+	var ret []*destinationBatch
+	for start := 0; start < len(events); {
+		end := len(events)
+		if d.batchSize > 0 && end-start > d.batchSize {
+			end = start + d.batchSize
+		}
+		ret = append(ret, &destinationBatch{
+			dest:   d,
+			parent: routed,
+			events: events[start:end],
+			ttl:    d.retry,
+		})
+		start = end
+	}
+	return ret
+}
+
+// routedBatch is a batch of the pipeline split into destination batches. The
+// mirrored batches have no routed batch.
+type routedBatch struct {
+	batch    publisher.Batch
+	observer outputs.Observer
+	events   int
+	pending  atomic.Int32
+	// dropped is the number of events unrouted or dropped by a destination
+	dropped atomic.Int32
+}
+
+// done acks the batch once all destination batches are done, and reports its
+// events to the observer of the output.
+func (b *routedBatch) done() {
+	if b == nil {
+		return
+	}
+	if b.pending.Add(-1) == 0 {
+		// an event dropped by several destinations is counted once per
+		// destination, the drops never exceed the events of the batch
+		dropped := min(int(b.dropped.Load()), b.events)
+		b.observer.Acked(b.events - dropped)
+		if dropped > 0 {
+			b.observer.Dropped(dropped)
+		}
+		b.batch.ACK()
+	}
+}
+
+func (b *routedBatch) drop(n int) {
+	if b == nil {
+		return
+	}
+	b.dropped.Add(int32(n))
+	b.done()
+}
+
+// destinationBatch is the batch of a destination, retried by the workers of
+// the destination up to its max_retries.
+type destinationBatch struct {
+	dest   *destination
+	parent *routedBatch
+	events []publisher.Event
+	// ttl is the number of retries left, -1 retries forever
+	ttl int
+}
+
+func (b *destinationBatch) Events() []publisher.Event {
+	return b.events
+}
+
+func (b *destinationBatch) ACK() {
+	b.dest.acked.Add(int64(len(b.events)))
+	b.parent.done()
+}
+
+func (b *destinationBatch) Drop() {
+	b.drop(len(b.events))
+}
+
+func (b *destinationBatch) Retry() {
+	b.RetryEvents(b.events)
+}
+
+func (b *destinationBatch) RetryEvents(events []publisher.Event) {
+	b.dest.acked.Add(int64(len(b.events) - len(events)))
+	if b.ttl == 0 {
+		b.drop(len(events))
+		return
+	}
+	if b.ttl > 0 {
+		b.ttl--
+	}
+	b.events = events
+	b.dest.queue.requeue(b)
+}
+
+func (b *destinationBatch) Cancelled() {
+	b.dest.queue.requeue(b)
+}
+
+func (b *destinationBatch) drop(n int) {
+	b.dest.dropped.Add(int64(n))
+	b.dest.log.Errorf("destination %s dropped %d events after %d retries", b.dest.name, n, b.dest.retry)
+	b.parent.drop(n)
+}
+
+// destinationObserver is the observer of the clients of a destination. The
+// events are counted by the destination and reported to the observer of the
+// output once per pipeline batch, only the network stats are passed on.
+type destinationObserver struct {
+	outputs.Observer
+}
+
+func (destinationObserver) NewBatch(int)  {}
+func (destinationObserver) Acked(int)     {}
+func (destinationObserver) Failed(int)    {}
+func (destinationObserver) Dropped(int)   {}
+func (destinationObserver) Duplicate(int) {}
+func (destinationObserver) Cancelled(int) {}
+
+// destinationQueue buffers the batches of a destination. Pushing a batch
+// waits while the queue is full, the retried batches are queued at once.
+type destinationQueue struct {
+	size  int
+	gauge *monitoring.Int
+
+	mu      sync.Mutex
+	batches []*destinationBatch
+	closed  bool
+	// changed is closed and replaced on every change
+	changed chan struct{}
+}
+
+func newDestinationQueue(size int, gauge *monitoring.Int) *destinationQueue {
+	return &destinationQueue{
+		size:    size,
+		gauge:   gauge,
+		changed: make(chan struct{}),
+	}
+}
+
+// push waits until the queue has room for b, it reports false when the queue
+// is closed.
+func (q *destinationQueue) push(b *destinationBatch) bool {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return false
+		}
+		if len(q.batches) < q.size {
+			q.batches = append(q.batches, b)
+			q.notify()
+			q.mu.Unlock()
+			return true
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		<-changed
+	}
+}
+
+// offer queues b if the queue has room, it never waits.
+func (q *destinationQueue) offer(b *destinationBatch) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.batches) >= q.size {
+		return false
+	}
+	q.batches = append(q.batches, b)
+	q.notify()
+	return true
+}
+
+// requeue puts a retried batch in front of the queue.
+func (q *destinationQueue) requeue(b *destinationBatch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.batches = append([]*destinationBatch{b}, q.batches...)
+	q.notify()
+}
+
+// pop waits for the next batch, it reports false when the queue is closed.
+func (q *destinationQueue) pop() (*destinationBatch, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false
+		}
+		if len(q.batches) > 0 {
+			b := q.batches[0]
+			q.batches = q.batches[1:]
+			q.notify()
+			q.mu.Unlock()
+			return b, true
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		<-changed
+	}
+}
+
+func (q *destinationQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notify()
+}
+
+func (q *destinationQueue) notify() {
+	q.gauge.Set(int64(len(q.batches)))
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
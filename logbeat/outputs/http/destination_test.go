@@ -0,0 +1,339 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// recordServer records the bodies it receives and answers with status.
+type recordServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newRecordServer(status int) *recordServer {
+	s := &recordServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *recordServer) received() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.bodies, "\n")
+}
+
+func (s *recordServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func (s *recordServer) host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestDestinations(t *testing.T) {
+	tracking := newRecordServer(http.StatusOK)
+	defer tracking.Close()
+	logs := newRecordServer(http.StatusOK)
+	defer logs.Close()
+	failing := newRecordServer(http.StatusInternalServerError)
+	defer failing.Close()
+
+	stats := monitoring.NewRegistry()
+	out, err := makeHttp(nil, beat.Info{}, outputs.NewStats(stats), config.MustNewConfigFrom(map[string]any{
+		"destinations": []map[string]any{
+			{
+				"name":                  "tracking",
+				"hosts":                 []string{tracking.host()},
+				"channel":               "sa",
+				"batch_mode":            true,
+				"when.contains.message": "event",
+			},
+			{
+				"name":                      "logs",
+				"hosts":                     []string{logs.host()},
+				"channel":                   "openobserve",
+				"batch_mode":                true,
+				"when.not.contains.message": "event",
+			},
+			{
+				"name":         "failing",
+				"hosts":        []string{failing.host()},
+				"max_retries":  1,
+				"backoff.init": "1ms",
+				"backoff.max":  "1ms",
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := out.Clients[0]
+	defer router.Close()
+
+	acked := make(chan struct{})
+	batch := outest.NewBatch(
+		beat.Event{Fields: mapstr.M{"message": `{"event":"login"}`}},
+		beat.Event{Fields: mapstr.M{"message": `{"level":"info"}`}},
+		beat.Event{Fields: mapstr.M{"message": `{"level":"error"}`}},
+	)
+	batch.OnSignal = func(sig outest.BatchSignal) {
+		if sig.Tag == outest.BatchACK {
+			close(acked)
+		}
+	}
+	if err := router.Publish(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not acked")
+	}
+
+	if got := tracking.received(); tracking.requests() != 1 || !strings.HasPrefix(got, "data=") {
+		t.Errorf("unexpected tracking requests: %q", got)
+	}
+	if got := logs.received(); strings.Count(got, `"log"`) != 2 || strings.Contains(got, "login") {
+		t.Errorf("unexpected logs requests: %q", got)
+	}
+	// the failing destination tried the first event twice, then dropped the batch
+	if n := failing.requests(); n != 2 {
+		t.Errorf("expected 2 requests to the failing destination, got %d", n)
+	}
+
+	// the batch is reported once to the observer of the output, whatever the
+	// number of destinations of its events
+	for name, expected := range map[string]uint64{"events.batches": 1, "events.total": 3, "events.acked": 0, "events.dropped": 3} {
+		if n := stats.Get(name).(*monitoring.Uint).Get(); n != expected {
+			t.Errorf("expected %s %d, got %d", name, expected, n)
+		}
+	}
+}
+
+func TestDestinationsClosed(t *testing.T) {
+	srv := newRecordServer(http.StatusOK)
+	defer srv.Close()
+
+	stats := monitoring.NewRegistry()
+	out, err := makeHttp(nil, beat.Info{}, outputs.NewStats(stats), config.MustNewConfigFrom(map[string]any{
+		"destinations": []map[string]any{
+			{"name": "first", "hosts": []string{srv.host()}, "channel": "openobserve"},
+			{"name": "second", "hosts": []string{srv.host()}, "channel": "openobserve"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := out.Clients[0].(*destinationRouter)
+	defer router.Close()
+	router.destinations[1].queue.close()
+
+	// the batch queued by the first destination only is cancelled, not acked
+	batch := outest.NewBatch(beat.Event{Fields: mapstr.M{"message": "test"}})
+	if err = router.Publish(context.Background(), batch); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+	if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchCancelled {
+		t.Fatalf("expected the batch to be cancelled, got %v", batch.Signals)
+	}
+	if n := stats.Get("events.acked").(*monitoring.Uint).Get(); n != 0 {
+		t.Fatalf("expected no acked events, got %d", n)
+	}
+}
+
+func TestDestinationsConfig(t *testing.T) {
+	tests := []struct {
+		destinations []map[string]any
+		err          string
+	}{
+		{
+			destinations: []map[string]any{{"hosts": []string{"127.0.0.1:5080"}}},
+			err:          "name",
+		},
+		{
+			destinations: []map[string]any{{"name": "a.b", "hosts": []string{"127.0.0.1:5080"}}},
+			err:          "dots",
+		},
+		{
+			destinations: []map[string]any{
+				{"name": "a", "hosts": []string{"127.0.0.1:5080"}},
+				{"name": "a", "hosts": []string{"127.0.0.1:5081"}},
+			},
+			err: "duplicate",
+		},
+		{
+			destinations: []map[string]any{{"name": "a"}},
+			err:          "hosts",
+		},
+		{
+			destinations: []map[string]any{{"name": "a", "hosts": []string{"127.0.0.1:5080"}, "queue.mem.events": 4096}},
+			err:          "queue_size",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := makeHttp(nil, beat.Info{}, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+			"destinations": test.destinations,
+		}))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q, got %v", test.destinations, test.err, err)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	var settings struct {
+		Routes []routeConfig `config:"routes"`
+	}
+	err := config.MustNewConfigFrom(map[string]any{
+		"routes": []map[string]any{
+			{"destination": "tracking", "when.equals.fields.type": "track"},
+			{"destination": "tracking", "when.regexp.message": `"#event_name":`},
+			{"destination": "logs"},
+		},
+	}).Unpack(&settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := &destinationRouter{
+		destinations: []*destination{{name: "logs"}, {name: "tracking"}},
+	}
+	if router.routes, err = newRoutes(settings.Routes, router.destinations); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []publisher.Event{
+		{Content: beat.Event{Fields: mapstr.M{"message": "a", "fields": mapstr.M{"type": "track"}}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "b"}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": `{"#event_name":"login"}`}}},
+		{Content: beat.Event{Fields: mapstr.M{"message": "d"}}},
+	}
+	groups, unrouted := router.route(events)
+	if unrouted != 0 {
+		t.Errorf("expected all events routed, got %d unrouted", unrouted)
+	}
+
+	expected := []string{"b,d", `a,{"#event_name":"login"}`}
+	for i, group := range groups {
+		var msgs []string
+		for _, e := range group {
+			msg, _ := e.Content.Fields.GetValue("message")
+			msgs = append(msgs, msg.(string))
+		}
+		if got := strings.Join(msgs, ","); got != expected[i] {
+			t.Errorf("%s: expected %s, got %s", router.destinations[i].name, expected[i], got)
+		}
+	}
+
+	// an unknown destination, or a destination condition with routes
+	if _, err = newRoutes([]routeConfig{{Destination: "other"}}, router.destinations); err == nil {
+		t.Error("expected an unknown destination error")
+	}
+	router.destinations[0].cond, _ = conditions.NewCondition(&conditions.Config{HasFields: []string{"message"}})
+	if _, err = newRoutes(settings.Routes, router.destinations); err == nil {
+		t.Error("expected an error for the condition of the destination")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	var primaryRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request fails and is retried
+		if primaryRequests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer primary.Close()
+	shadow := newRecordServer(http.StatusOK)
+	defer shadow.Close()
+
+	out, err := makeHttp(nil, beat.Info{}, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts":        []string{strings.TrimPrefix(primary.URL, "http://")},
+		"channel":      "openobserve",
+		"batch_mode":   true,
+		"backoff.init": "1ms",
+		"backoff.max":  "1ms",
+		"mirror": map[string]any{
+			"hosts":      []string{shadow.host()},
+			"channel":    "openobserve",
+			"batch_mode": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := out.Clients[0].(*mirrorClient)
+	defer cli.Close()
+
+	batch := outest.NewBatch(createEvent(1), createEvent(2))
+	if err := cli.Publish(context.Background(), batch); err == nil {
+		t.Fatal("expected the first request to fail")
+	}
+	retried := &eventsBatch{Batch: outest.NewBatch(), events: batch.Signals[0].Events}
+	if err := cli.Publish(context.Background(), retried); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := cli.mirror.dest
+	deadline := time.Now().Add(5 * time.Second)
+	for dest.acked.Get() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if acked, routed := dest.acked.Get(), dest.routed.Get(); acked != 2 || routed != 2 {
+		t.Fatalf("expected 2 mirrored events, got %d routed, %d acked", routed, acked)
+	}
+	if n := shadow.requests(); n != 1 {
+		t.Fatalf("expected the retried batch not mirrored again, got %d requests", n)
+	}
+
+	// the mirror block of the user config is left unchanged
+	mirrorCfg := config.MustNewConfigFrom(map[string]any{"hosts": []string{shadow.host()}})
+	m, err := newMirror(mirrorCfg, beat.Info{}, monitoring.NewRegistry(), logp.NewLogger(loggerName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = m.dest.close()
+	if mirrorCfg.HasField("name") {
+		t.Fatal("expected the mirror config not to be modified")
+	}
+}
+
+// eventsBatch is a batch of publisher events, keeping their event cache.
+type eventsBatch struct {
+	*outest.Batch
+	events []publisher.Event
+}
+
+func (b *eventsBatch) Events() []publisher.Event {
+	return b.events
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discoverySRV = "srv"
+	discoveryA   = "a"
+)
+
+type discoveryConfig struct {
+	Type     string        `config:"type"`
+	Name     string        `config:"name"`
+	Port     int           `config:"port"`
+	Interval time.Duration `config:"interval"`
+	Timeout  time.Duration `config:"timeout"`
+	// Workers is the number of output workers, which can't change at runtime.
+	// Defaults to the number of hosts of the first resolution.
+	Workers int `config:"workers" validate:"min=0"`
+}
+
+var defaultDiscoveryConfig = discoveryConfig{
+	Type:     discoverySRV,
+	Interval: 30 * time.Second,
+	Timeout:  5 * time.Second,
+}
+
+func (c *discoveryConfig) enabled() bool {
+	return c.Name != ""
+}
+
+func (c *discoveryConfig) Validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	switch c.Type {
+	case discoverySRV:
+	case discoveryA:
+		if c.Port <= 0 || c.Port > 65535 {
+			return fmt.Errorf("discovery.port is required for %s records", c.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported discovery.type: %s", c.Type)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("discovery.interval must be positive")
+	}
+	return nil
+}
+
+// discoveredHost is a host resolved from DNS, weight 0 is the configured
+// weight of the host.
+type discoveredHost struct {
+	host   string
+	weight int
+	backup bool
+}
+
+// hostResolver resolves the output hosts from DNS. The hosts of SRV records
+// are the targets with their ports, the hosts of A records are the addresses
+// with the configured port.
+type hostResolver struct {
+	conf     *discoveryConfig
+	resolver *net.Resolver
+}
+
+func newHostResolver(conf *discoveryConfig) *hostResolver {
+	return &hostResolver{
+		conf:     conf,
+		resolver: net.DefaultResolver,
+	}
+}
+
+func (r *hostResolver) resolve() ([]discoveredHost, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.conf.Timeout)
+	defer cancel()
+
+	var hosts []discoveredHost
+	switch r.conf.Type {
+	case discoverySRV:
+		_, records, err := r.resolver.LookupSRV(ctx, "", "", r.conf.Name)
+		if err != nil {
+			return nil, err
+		}
+		hosts = srvHosts(records)
+	default:
+		addrs, err := r.resolver.LookupHost(ctx, r.conf.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			hosts = append(hosts, discoveredHost{host: net.JoinHostPort(addr, strconv.Itoa(r.conf.Port))})
+		}
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].host < hosts[j].host })
+	return hosts, nil
+}
+
+// srvHosts maps the SRV records to hosts: the targets of the lowest priority
+// are the primary hosts, the others are backup hosts. The SRV weight is the
+// host weight, a weight of 0 keeps the configured one.
+func srvHosts(records []*net.SRV) []discoveredHost {
+	if len(records) == 0 {
+		return nil
+	}
+	priority := records[0].Priority
+	for _, rec := range records {
+		priority = min(priority, rec.Priority)
+	}
+
+	hosts := make([]discoveredHost, len(records))
+	for i, rec := range records {
+		target := strings.TrimSuffix(rec.Target, ".")
+		hosts[i] = discoveredHost{
+			host:   net.JoinHostPort(target, strconv.Itoa(int(rec.Port))),
+			weight: int(rec.Weight),
+			backup: rec.Priority > priority,
+		}
+	}
+	return hosts
+}
+
+// primaryHosts returns the names of the primary hosts.
+func primaryHosts(hosts []discoveredHost) []string {
+	var names []string
+	for _, h := range hosts {
+		if !h.backup {
+			names = append(names, h.host)
+		}
+	}
+	return names
+}
+
+// startDiscovery re-resolves the hosts every interval and updates the pool.
+// A failed resolution keeps the current hosts.
+func (p *hostPool) startDiscovery(protocol string, r *hostResolver) {
+	go func() {
+		ticker := time.NewTicker(r.conf.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+
+			hosts, err := r.resolve()
+			if err != nil {
+				p.log.Errorf("failed to resolve %s: %v", r.conf.Name, err)
+				continue
+			}
+			if len(hosts) == 0 {
+				p.log.Warnf("no hosts resolved from %s, keeping the current hosts", r.conf.Name)
+				continue
+			}
+			p.sync(protocol, hosts)
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSRVHosts(t *testing.T) {
+	hosts := srvHosts([]*net.SRV{
+		{Target: "b.logs.", Port: 5080, Priority: 20, Weight: 0},
+		{Target: "a.logs.", Port: 5080, Priority: 10, Weight: 3},
+		{Target: "c.logs.", Port: 5081, Priority: 10, Weight: 1},
+	})
+	expected := []discoveredHost{
+		{host: "b.logs:5080", weight: 0, backup: true},
+		{host: "a.logs:5080", weight: 3},
+		{host: "c.logs:5081", weight: 1},
+	}
+	if len(hosts) != len(expected) {
+		t.Fatalf("unexpected hosts %v", hosts)
+	}
+	for i := range hosts {
+		if hosts[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], hosts[i])
+		}
+	}
+	if got := strings.Join(primaryHosts(hosts), ","); got != "a.logs:5080,c.logs:5081" {
+		t.Errorf("unexpected primary hosts %s", got)
+	}
+}
+
+func TestHostPoolSync(t *testing.T) {
+	conf := defaultBalanceConfig
+	conf.BackupHosts = []string{"z"}
+	conf.HostWeights = []hostWeight{{Host: "b", Weight: 4}}
+	pool := newTestPool(t, conf)
+
+	endpoints := func() string {
+		t.Helper()
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		var eps []string
+		for _, ep := range pool.endpoints {
+			s := ep.host
+			if ep.backup {
+				s += "(backup)"
+			}
+			eps = append(eps, s)
+		}
+		return strings.Join(eps, ",")
+	}
+
+	pool.sync("http", []discoveredHost{{host: "a", weight: 2}, {host: "b"}})
+	if got := endpoints(); got != "z(backup),a,b" {
+		t.Fatalf("unexpected endpoints %s", got)
+	}
+	a, b := pool.endpoints[1], pool.endpoints[2]
+	if a.weight != 2 || b.weight != 4 {
+		t.Fatalf("unexpected weights %d and %d", a.weight, b.weight)
+	}
+
+	// the hosts still present keep their state, the others are removed
+	pool.report(a, errors.New("unavailable"))
+	pool.sync("http", []discoveredHost{{host: "a", backup: true}, {host: "c"}})
+	if got := endpoints(); got != "z(backup),a(backup),c" {
+		t.Fatalf("unexpected endpoints %s", got)
+	}
+	if pool.endpoints[1] != a || a.failures != 1 || a.weight != 1 {
+		t.Errorf("expected host a to be kept with 1 failure and weight 1, got %d and %d", a.failures, a.weight)
+	}
+	if !pool.isRemoved(b) || pool.isRemoved(a) {
+		t.Error("expected host b to be removed")
+	}
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// The reasons of dropped events.
+const (
+	dropEmptyMessage = "empty_message"
+	dropInvalidJSON  = "invalid_json"
+	dropInvalidEvent = "invalid_event"
+	dropEncodeFailed = "encode_failed"
+	dropRejected     = "rejected"
+	dropSpoolEvicted = "spool_evicted"
+)
+
+// At most dropLogBurst errors are logged at once, then one per second.
+const (
+	dropLogRate  = 1
+	dropLogBurst = 10
+)
+
+// rejectedError reports events rejected permanently by the server, they are
+// dropped instead of retried. Zero events means all events of the request.
+type rejectedError struct {
+	events int
+	msg    string
+}
+
+func (e *rejectedError) Error() string {
+	return e.msg
+}
+
+// errPartialSuccess is wrapped by the errors of batches published in part,
+// the host is up but some events are retried.
+var errPartialSuccess = errors.New("partial success")
+
+// partialError reports a request accepted in part by the server: the events
+// at the retry indexes are published again, the rejected ones are dropped and
+// the others are acked.
+type partialError struct {
+	retry    []int
+	rejected []int
+	msg      string
+	// duplicates were accepted by a previous request, tooMany were
+	// retried after a 429
+	duplicates int
+	tooMany    int
+}
+
+func (e *partialError) Error() string {
+	return e.msg
+}
+
+// dropStats counts the dropped events by reason, and logs a sample of the
+// errors to not flood the logs with the same error for every line of a file.
+type dropStats struct {
+	log      *logp.Logger
+	counters map[string]*monitoring.Int
+
+	limiter    *rate.Limiter
+	suppressed atomic.Int64
+}
+
+func newDropStats(reg *monitoring.Registry, log *logp.Logger) *dropStats {
+	dropReg := subRegistry(reg, "dropped")
+	counters := make(map[string]*monitoring.Int)
+	for _, reason := range []string{dropEmptyMessage, dropInvalidJSON, dropInvalidEvent, dropEncodeFailed, dropRejected, dropSpoolEvicted} {
+		counters[reason] = monitoring.NewInt(dropReg, reason)
+	}
+	return &dropStats{
+		log:      log,
+		counters: counters,
+		limiter:  rate.NewLimiter(dropLogRate, dropLogBurst),
+	}
+}
+
+// dropReason returns the reason of an error of makeEvent.
+func dropReason(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, ErrEmptyMessage), errors.Is(err, mapstr.ErrKeyNotFound):
+		return dropEmptyMessage
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return dropInvalidJSON
+	default:
+		return dropInvalidEvent
+	}
+}
+
+func (d *dropStats) count(reason string, n int) {
+	if d == nil || n <= 0 {
+		return
+	}
+	d.counters[reason].Add(int64(n))
+}
+
+// event counts a dropped event and logs it with its source file and offset.
+func (d *dropStats) event(reason string, v beat.Event, err error) {
+	if d == nil {
+		return
+	}
+	d.count(reason, 1)
+
+	path, offset, ok := eventSource(v)
+	if !ok {
+		d.logf("dropping event (%s): %v", reason, err)
+		return
+	}
+	d.logf("dropping event (%s) from %v at offset %v: %v", reason, path, offset, err)
+}
+
+// logRejected logs n events of data rejected by the server at url, with the
+// source of the first event: the servers don't always tell which events of
+// the request they rejected.
+func (d *dropStats) logRejected(data []publisher.Event, n int, url, msg string) {
+	if d == nil {
+		return
+	}
+	if len(data) > 0 {
+		if path, offset, ok := eventSource(data[0].Content); ok {
+			d.logf("dropping %d events rejected by %s, from %v at offset %v: %s", n, url, path, offset, msg)
+			return
+		}
+	}
+	d.logf("dropping %d events rejected by %s: %s", n, url, msg)
+}
+
+// eventSource returns the file path and offset of an event read by filebeat.
+func eventSource(v beat.Event) (any, any, bool) {
+	path, err := v.Fields.GetValue("log.file.path")
+	if err != nil {
+		return nil, nil, false
+	}
+	offset, _ := v.Fields.GetValue("log.offset")
+	return path, offset, true
+}
+
+// logf logs an error if the rate allows it, the number of suppressed errors
+// is added to the next logged one.
+func (d *dropStats) logf(format string, args ...any) {
+	if d == nil {
+		return
+	}
+	if !d.limiter.Allow() {
+		d.suppressed.Add(1)
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if n := d.suppressed.Swap(0); n > 0 {
+		msg = fmt.Sprintf("%s (%d similar errors suppressed)", msg, n)
+	}
+	d.log.Error(msg)
+}
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 )
@@ -149,6 +150,81 @@ func (b *gzipEncoder) Add(meta, obj any) error {
 	return nil
 }
 
+// compressBuffer is the body of the channel encoders, gzip compressed when
+// the compression level is set. The channel encoders don't support bulk
+// bodies.
+type compressBuffer struct {
+	buf  *bytes.Buffer
+	gzip *gzip.Writer
+}
+
+func newCompressBuffer(level int, buf *bytes.Buffer) (compressBuffer, error) {
+	if buf == nil {
+		buf = bytes.NewBuffer(nil)
+	}
+	if level <= 0 {
+		return compressBuffer{buf: buf}, nil
+	}
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return compressBuffer{}, err
+	}
+	return compressBuffer{buf, w}, nil
+}
+
+func (b *compressBuffer) Reset() {
+	b.buf.Reset()
+	if b.gzip != nil {
+		b.gzip.Reset(b.buf)
+	}
+}
+
+func (b *compressBuffer) Reader() io.Reader {
+	if b.gzip != nil {
+		b.gzip.Close()
+	}
+	return b.buf
+}
+
+func (b *compressBuffer) writer() io.Writer {
+	if b.gzip != nil {
+		return b.gzip
+	}
+	return b.buf
+}
+
+func (b *compressBuffer) addHeader(header *http.Header, contentType string) {
+	header.Add("Content-Type", contentType)
+	if b.gzip != nil {
+		header.Add("Content-Encoding", "gzip")
+	}
+}
+
+func (b *compressBuffer) AddRaw(raw any) error {
+	return errors.New("not implemented")
+}
+
+func (b *compressBuffer) Add(meta, obj any) error {
+	return errors.New("not implemented")
+}
+
+// eventList returns the events of a single event or event list body.
+func eventList(obj any) ([]eventRaw, error) {
+	var events []eventRaw
+	switch v := obj.(type) {
+	case eventRaw:
+		events = []eventRaw{v}
+	case []eventRaw:
+		events = v
+	default:
+		return nil, errors.New("unknown obj type")
+	}
+	if len(events) == 0 {
+		return nil, errors.New("empty event list")
+	}
+	return events, nil
+}
+
 func bulkEncode(out bodyEncoder, body []any) error {
 	out.Reset()
 	for _, obj := range body {
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// hecEncoder writes events one after another, the hec endpoints don't accept json arrays.
+type hecEncoder struct {
+	compressBuffer
+	raw bool
+}
+
+func newHECEncoder(raw bool, level int, buf *bytes.Buffer) (*hecEncoder, error) {
+	cb, err := newCompressBuffer(level, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &hecEncoder{compressBuffer: cb, raw: raw}, nil
+}
+
+func (b *hecEncoder) AddHeader(header *http.Header) {
+	if b.raw {
+		b.addHeader(header, "text/plain; charset=UTF-8")
+	} else {
+		b.addHeader(header, "application/json; charset=UTF-8")
+	}
+}
+
+func (b *hecEncoder) Marshal(obj any) error {
+	b.Reset()
+
+	switch v := obj.(type) {
+	case eventRaw:
+		return b.addEvent(v)
+	case []eventRaw:
+		if len(v) == 0 {
+			return errors.New("empty event list")
+		}
+		for _, e := range v {
+			if err := b.addEvent(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return json.NewEncoder(b.writer()).Encode(obj)
+	}
+}
+
+func (b *hecEncoder) addEvent(e eventRaw) error {
+	w := b.writer()
+	if !b.raw {
+		return json.NewEncoder(w).Encode(e)
+	}
+
+	msg := e[originMsgKey]
+	if len(msg) == 0 {
+		return errors.New("empty event")
+	}
+	w.Write(msg)
+	w.Write([]byte{'\n'})
+	return nil
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// newRelicEncoder wraps the logs into the detailed json format: [{"common":{"attributes":{}},"logs":[]}]
+type newRelicEncoder struct {
+	compressBuffer
+	common map[string]string
+}
+
+type newRelicCommon struct {
+	Attributes map[string]string `json:"attributes"`
+}
+
+type newRelicPayload struct {
+	Common newRelicCommon `json:"common"`
+	Logs   []eventRaw     `json:"logs"`
+}
+
+func newNewRelicEncoder(common map[string]string, level int, buf *bytes.Buffer) (*newRelicEncoder, error) {
+	cb, err := newCompressBuffer(level, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &newRelicEncoder{compressBuffer: cb, common: common}, nil
+}
+
+func (b *newRelicEncoder) AddHeader(header *http.Header) {
+	b.addHeader(header, "application/json")
+}
+
+func (b *newRelicEncoder) Marshal(obj any) error {
+	b.Reset()
+
+	logs, err := eventList(obj)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(b.writer()).Encode([]newRelicPayload{{
+		Common: newRelicCommon{Attributes: b.common},
+		Logs:   logs,
+	}})
+}
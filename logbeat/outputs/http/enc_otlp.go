@@ -0,0 +1,174 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpEncoder wraps the log records into an ExportLogsServiceRequest.
+type otlpEncoder struct {
+	compressBuffer
+	json     bool
+	resource []otlpKeyValue
+	scope    otlpScope
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpExportRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope  `json:"scope"`
+	LogRecords []eventRaw `json:"logRecords"`
+}
+
+func newOTLPEncoder(conf *otlpConfig, info beat.Info, level int, buf *bytes.Buffer) (*otlpEncoder, error) {
+	cb, err := newCompressBuffer(level, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpEncoder{
+		compressBuffer: cb,
+		json:           conf.Encoding == otlpEncodingJSON,
+		resource:       conf.resource(info),
+		scope:          otlpScope{Name: "logbeat", Version: info.Version},
+	}, nil
+}
+
+func (b *otlpEncoder) AddHeader(header *http.Header) {
+	if b.json {
+		b.addHeader(header, "application/json")
+	} else {
+		b.addHeader(header, "application/x-protobuf")
+	}
+}
+
+func (b *otlpEncoder) Marshal(obj any) error {
+	b.Reset()
+
+	records, err := eventList(obj)
+	if err != nil {
+		return err
+	}
+	if b.json {
+		return json.NewEncoder(b.writer()).Encode(otlpExportRequest{
+			ResourceLogs: []otlpResourceLogs{{
+				Resource:  otlpResource{Attributes: b.resource},
+				ScopeLogs: []otlpScopeLogs{{Scope: b.scope, LogRecords: records}},
+			}},
+		})
+	}
+
+	msg, err := b.appendRequest(nil, records)
+	if err != nil {
+		return err
+	}
+	_, err = b.writer().Write(msg)
+	return err
+}
+
+// appendRequest encodes the ExportLogsServiceRequest of the encoded records, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/logs/v1/logs.proto
+func (b *otlpEncoder) appendRequest(buf []byte, records []eventRaw) ([]byte, error) {
+	var resource []byte
+	for _, kv := range b.resource {
+		resource = appendProtoMessage(resource, 1, appendOTLPKeyValue(nil, kv))
+	}
+
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, b.scope.Name)
+	if b.scope.Version != "" {
+		scope = protowire.AppendTag(scope, 2, protowire.BytesType)
+		scope = protowire.AppendString(scope, b.scope.Version)
+	}
+
+	var scopeLogs []byte
+	scopeLogs = appendProtoMessage(scopeLogs, 1, scope)
+	for _, e := range records {
+		record, ok := e[otlpRecordKey]
+		if !ok {
+			return nil, errors.New("otlp record not encoded in protobuf")
+		}
+		scopeLogs = appendProtoMessage(scopeLogs, 2, record)
+	}
+
+	var resourceLogs []byte
+	resourceLogs = appendProtoMessage(resourceLogs, 1, resource)
+	resourceLogs = appendProtoMessage(resourceLogs, 2, scopeLogs)
+
+	return appendProtoMessage(buf, 1, resourceLogs), nil
+}
+
+func appendOTLPLogRecord(b []byte, r *otlpLogRecord) []byte {
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, r.TimeUnixNano)
+
+	if r.SeverityNumber != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.SeverityNumber))
+	}
+	if r.SeverityText != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, r.SeverityText)
+	}
+
+	b = appendProtoMessage(b, 5, appendOTLPAnyValue(nil, r.Body))
+	for _, kv := range r.Attributes {
+		b = appendProtoMessage(b, 6, appendOTLPKeyValue(nil, kv))
+	}
+
+	if len(r.TraceId) > 0 {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.TraceId)
+	}
+	if len(r.SpanId) > 0 {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.SpanId)
+	}
+
+	b = protowire.AppendTag(b, 11, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, r.ObservedTimeUnixNano)
+}
+
+func appendOTLPKeyValue(b []byte, kv otlpKeyValue) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, kv.Key)
+	return appendProtoMessage(b, 2, appendOTLPAnyValue(nil, kv.Value))
+}
+
+func appendOTLPAnyValue(b []byte, v otlpAnyValue) []byte {
+	if v.IntValue != "" {
+		if i, err := strconv.ParseInt(v.IntValue, 10, 64); err == nil {
+			b = protowire.AppendTag(b, 3, protowire.VarintType)
+			return protowire.AppendVarint(b, uint64(i))
+		}
+	}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendString(b, v.StringValue)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
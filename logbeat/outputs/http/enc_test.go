@@ -0,0 +1,289 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+func encoderEvents(t *testing.T, settings clientSettings, msgs ...string) []eventRaw {
+	t.Helper()
+	events := make([]eventRaw, len(msgs))
+	for i, msg := range msgs {
+		e, err := makeEvent(goldenEvent(msg), &settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events[i] = e
+	}
+	return events
+}
+
+func decompress(t *testing.T, b []byte) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// TestSaEncoder checks the form sent to Sensors Data. The golden files hold
+// the decoded data, the compressed bytes depend on compress/flate.
+func TestSaEncoder(t *testing.T) {
+	msgs := []string{
+		`{"type":"track","event":"login","distinct_id":"1"}`,
+		`{"type":"profile_set","distinct_id":"1","properties":{"name":"a b&c"}}`,
+	}
+	tests := []struct {
+		name  string
+		obj   any
+		param string
+	}{
+		{"sa_single", encoderEvents(t, clientSettings{Channel: channelSa}, msgs[0])[0], "data"},
+		{"sa_single_list", encoderEvents(t, clientSettings{Channel: channelSa}, msgs[0]), "data"},
+		{"sa_list", encoderEvents(t, clientSettings{Channel: channelSa}, msgs...), "data_list"},
+	}
+
+	enc := newSaEncoder(nil)
+	for _, test := range tests {
+		if err := enc.Marshal(test.obj); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		body, _ := io.ReadAll(enc.Reader())
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(form) != 2 || form.Get("gzip") != "1" || len(form[test.param]) != 1 {
+			t.Fatalf("%s: unexpected form %s", test.name, body)
+		}
+		data, err := base64.StdEncoding.DecodeString(form.Get(test.param))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkGolden(t, "encoder/"+test.name, append(decompress(t, data), '\n'))
+	}
+
+	header := http.Header{}
+	enc.AddHeader(&header)
+	if header.Get("Content-Type") != "application/x-www-form-urlencoded" || header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	for _, obj := range []any{[]eventRaw{}, eventRaw{}, "text"} {
+		if err := enc.Marshal(obj); err == nil {
+			t.Errorf("expected an error for %#v", obj)
+		}
+	}
+}
+
+// TestGzipEncoder checks that the decompressed bodies of gzipEncoder are the
+// bodies of jsonEncoder.
+func TestGzipEncoder(t *testing.T) {
+	events := encoderEvents(t, clientSettings{Channel: channelOpenObserve},
+		`GET /index.html 200`, `{"level":"error","trace_id":"4bf92f3577b34da6","msg":"<failed> & retried"}`)
+	bulk := encoderEvents(t, clientSettings{Channel: channelESBulk}, `{"msg":"a"}`, `plain text`)
+	meta := map[string]any{"index": map[string]any{"_index": "logs"}}
+
+	tests := []struct {
+		name   string
+		encode func(enc bodyEncoder) error
+	}{
+		{"json_single", func(enc bodyEncoder) error { return enc.Marshal(events[0]) }},
+		{"json_list", func(enc bodyEncoder) error { return enc.Marshal(events) }},
+		{"json_bulk", func(enc bodyEncoder) error {
+			enc.Reset()
+			for _, e := range bulk {
+				if err := enc.Add(meta, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"json_raw", func(enc bodyEncoder) error {
+			return bulkEncode(enc, []any{meta, bulk[0], meta, bulk[1]})
+		}},
+	}
+
+	gz, err := newGzipEncoder(gzip.BestCompression, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := newJSONEncoder(nil)
+	for _, test := range tests {
+		if err = test.encode(js); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expected, _ := io.ReadAll(js.Reader())
+		checkGolden(t, "encoder/"+test.name, expected)
+
+		// the encoder is reused between the requests
+		for i := 0; i < 2; i++ {
+			if err = test.encode(gz); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			body, _ := io.ReadAll(gz.Reader())
+			if got := decompress(t, body); !bytes.Equal(got, expected) {
+				t.Errorf("%s: gzip body differs\ngot:\n%s\nexpected:\n%s", test.name, got, expected)
+			}
+		}
+	}
+
+	header := http.Header{}
+	gz.AddHeader(&header)
+	if header.Get("Content-Type") != "application/json; charset=UTF-8" || header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	if _, err = newGzipEncoder(10, nil); err == nil {
+		t.Error("expected an error for compression level 10")
+	}
+	if err = gz.Marshal(json.RawMessage(`{`)); err == nil {
+		t.Error("expected an error for invalid json")
+	}
+}
+
+// otlpRequest returns an empty ExportLogsServiceRequest of the otlp proto
+// descriptor in testdata.
+func otlpRequest(t *testing.T) *dynamicpb.Message {
+	t.Helper()
+	text, err := os.ReadFile("testdata/otlp/logs.prototext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fdp descriptorpb.FileDescriptorProto
+	if err = prototext.Unmarshal(text, &fdp); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(&fdp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamicpb.NewMessage(fd.Messages().ByName("ExportLogsServiceRequest"))
+}
+
+// hexIds replaces the base64 trace and span ids of protojson with the hex
+// ids of the otlp json mapping.
+func hexIds(t *testing.T, v any) {
+	t.Helper()
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if s, ok := val.(string); ok && (key == "traceId" || key == "spanId") {
+				b, err := base64.StdEncoding.DecodeString(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				v[key] = hex.EncodeToString(b)
+				continue
+			}
+			hexIds(t, val)
+		}
+	case []any:
+		for _, val := range v {
+			hexIds(t, val)
+		}
+	}
+}
+
+// TestOTLPEncoder checks both encodings of the otlp requests, the protobuf
+// request decoded with the otlp proto is the json request.
+func TestOTLPEncoder(t *testing.T) {
+	msgs := []string{
+		`{"level":"error","trace_id":"4BF92F3577B34DA6A3CE929D0E0E4736","span_id":"00f067aa0ba902b7","msg":"failed"}`,
+		`[2024-05-01 12:30:45] WARN disk almost full`,
+		`plain text`,
+		`INFO trace_id:4bf92f3577b34da6 short trace id`,
+	}
+	severities := []float64{17, 13, 0, 9}
+	observed := time.Date(2024, 5, 1, 12, 30, 46, 0, time.UTC)
+	info := beat.Info{Hostname: "web-1", Version: "8.15.0"}
+
+	bodies := make(map[string][]byte)
+	for _, encoding := range []string{otlpEncodingJSON, otlpEncodingProtobuf} {
+		conf := otlpConfig{
+			Encoding:           encoding,
+			ServiceName:        "api",
+			ResourceAttributes: map[string]string{"host.arch": "amd64", "os.type": "linux"},
+		}
+		records := make([]eventRaw, len(msgs))
+		for i, msg := range msgs {
+			e := goldenEvent(msg)
+			if i == 2 {
+				_, _ = e.Fields.Put("log.offset", int64(42))
+			}
+			records[i] = makeOTLPLogRecord(e, msg, &conf, observed)
+		}
+
+		enc, err := newOTLPEncoder(&conf, info, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Marshal(records); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		body, _ := io.ReadAll(enc.Reader())
+		bodies[encoding] = body
+
+		if encoding == otlpEncodingJSON {
+			checkGolden(t, "encoder/otlp_json", body)
+		} else {
+			checkGolden(t, "encoder/otlp_protobuf", []byte(hex.Dump(body)))
+		}
+	}
+
+	req := otlpRequest(t)
+	if err := proto.Unmarshal(bodies[otlpEncodingProtobuf], req); err != nil {
+		t.Fatal(err)
+	}
+	if unknown := req.GetUnknown(); len(unknown) > 0 {
+		t.Fatalf("unknown fields %x", unknown)
+	}
+	b, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded, expected map[string]any
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	hexIds(t, decoded)
+	if err = json.Unmarshal(bodies[otlpEncodingJSON], &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("the protobuf request differs from the json request\nprotobuf: %s\njson: %s", b, bodies[otlpEncodingJSON])
+	}
+
+	records := decoded["resourceLogs"].([]any)[0].(map[string]any)["scopeLogs"].([]any)[0].(map[string]any)["logRecords"].([]any)
+	for i, r := range records {
+		severity, _ := r.(map[string]any)["severityNumber"].(float64)
+		if severity != severities[i] {
+			t.Errorf("%s: expected severity %v, got %v", msgs[i], severities[i], severity)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Package fakeserver provides local backends of the http output for tests.
+// The servers validate the requests like OpenObserve, ThinkingData and
+// Sensors Data, record the accepted events, and can be told to fail, answer
+// slowly or reject large requests.
+package fakeserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the backend mimicked by a server.
+type Kind string
+
+const (
+	OpenObserve  Kind = "openobserve"
+	ThinkingData Kind = "shushu"
+	SensorsData  Kind = "sa"
+)
+
+// Paths of the data apis.
+const (
+	OpenObservePath  = "/api/default/default/_json"
+	ThinkingDataPath = "/sync_json"
+	SensorsDataPath  = "/sa"
+)
+
+// Server is a fake backend, safe for concurrent use.
+type Server struct {
+	*httptest.Server
+	kind Kind
+
+	// Username and Password are the basic auth credentials of OpenObserve,
+	// AppId the ThinkingData project and Token the Sensors Data token. The
+	// requests aren't authenticated when they are empty.
+	Username string
+	Password string
+	AppId    string
+	Token    string
+	// MaxBodyBytes rejects the larger bodies with 413, 0 means no limit.
+	MaxBodyBytes int
+
+	mu       sync.Mutex
+	latency  time.Duration
+	faults   []int
+	requests int
+	events   []json.RawMessage
+}
+
+// New starts a server of kind, it must be closed.
+func New(kind Kind) *Server {
+	s := &Server{kind: kind}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Host returns the host:port of the server.
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Fail answers the next n requests with status, 429 has a Retry-After header.
+func (s *Server) Fail(status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.faults = append(s.faults, status)
+	}
+}
+
+// SetLatency delays the answers by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns the number of requests received, including the failed ones.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Events returns the accepted events: the log records of OpenObserve, the
+// data of ThinkingData and the records of Sensors Data.
+func (s *Server) Events() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.events...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	latency := s.latency
+	fault := 0
+	if len(s.faults) > 0 {
+		fault = s.faults[0]
+		s.faults = s.faults[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fault != 0 {
+		if fault == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, http.StatusText(fault), fault)
+		return
+	}
+
+	// the form of sensors data is sent with Content-Encoding gzip, only its
+	// data is compressed
+	body, err := readBody(r, s.MaxBodyBytes, s.kind != SensorsData)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch s.kind {
+	case OpenObserve:
+		s.handleOpenObserve(w, r, body)
+	case ThinkingData:
+		s.handleThinkingData(w, r, body)
+	case SensorsData:
+		s.handleSensorsData(w, r, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var errTooLarge = errors.New("request body too large")
+
+// readBody reads the body, decompressed if gzip encoded and decode is set.
+func readBody(r *http.Request, limit int, decode bool) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(body) > limit {
+		return nil, errTooLarge
+	}
+	if !decode || r.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+	return gunzip(body)
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// records decodes a json object or an array of objects.
+func records(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, rec := range list {
+			if !isObject(rec) {
+				return nil, fmt.Errorf("not a json object: %s", rec)
+			}
+		}
+		return list, nil
+	}
+	if !isObject(body) {
+		return nil, fmt.Errorf("not a json object: %s", body)
+	}
+	return []json.RawMessage{body}, nil
+}
+
+func isObject(b json.RawMessage) bool {
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(b, &obj) == nil && obj != nil
+}
+
+func (s *Server) accept(events []json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// handleOpenObserve accepts json records: POST /api/{org}/{stream}/_json.
+func (s *Server) handleOpenObserve(w http.ResponseWriter, r *http.Request, body []byte) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 4 || parts[0] != "api" || parts[3] != "_json" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.Username != "" || s.Password != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != s.Username || password != s.Password {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "message": "Unauthorized Access"})
+			return
+		}
+	}
+
+	recs, err := records(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "message": err.Error()})
+		return
+	}
+	s.accept(recs)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code":   200,
+		"status": []map[string]any{{"name": parts[2], "successful": len(recs), "failed": 0}},
+	})
+}
+
+// handleThinkingData accepts {"appid":"","data":{}} messages, or a list of
+// them: POST /sync_json.
+func (s *Server) handleThinkingData(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost || r.URL.Path != ThinkingDataPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("compress") == "gzip" {
+		var err error
+		if body, err = gunzip(body); err != nil {
+			writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+			return
+		}
+	}
+
+	recs, err := records(body)
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+		return
+	}
+	var data []json.RawMessage
+	for _, rec := range recs {
+		var msg struct {
+			AppId string          `json:"appid"`
+			Data  json.RawMessage `json:"data"`
+		}
+		if err = json.Unmarshal(rec, &msg); err != nil || !isObject(msg.Data) {
+			writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "invalid data"})
+			return
+		}
+		if s.AppId != "" && msg.AppId != s.AppId {
+			writeJSON(w, http.StatusOK, map[string]any{"code": -2, "msg": "appid not exists"})
+			return
+		}
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(msg.Data, &fields)
+		if _, ok := fields["#type"]; !ok {
+			writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "#type is required"})
+			return
+		}
+		data = append(data, msg.Data)
+	}
+	s.accept(data)
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0})
+}
+
+// handleSensorsData accepts the form data=<base64 gzip json>&gzip=1, or
+// data_list for a list of records: POST /sa?project=xxx&token=xxx.
+func (s *Server) handleSensorsData(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost || r.URL.Path != SensorsDataPath || r.URL.Query().Get("project") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.Token != "" && r.URL.Query().Get("token") != s.Token {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encoded, list := form.Get("data"), false
+	if encoded == "" {
+		encoded, list = form.Get("data_list"), true
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil && form.Get("gzip") == "1" {
+		data, err = gunzip(data)
+	}
+	if err != nil || len(data) == 0 {
+		http.Error(w, "invalid data", http.StatusBadRequest)
+		return
+	}
+
+	recs, err := records(data)
+	if err == nil && !list && len(recs) != 1 {
+		err = errors.New("data must be one record, use data_list")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, rec := range recs {
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(rec, &fields)
+		if _, ok := fields["type"]; !ok {
+			http.Error(w, "type is required", http.StatusBadRequest)
+			return
+		}
+	}
+	s.accept(recs)
+	w.WriteHeader(http.StatusOK)
+}
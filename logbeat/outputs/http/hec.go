@@ -0,0 +1,220 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// splunk hec referrer: https://docs.splunk.com/Documentation/Splunk/latest/Data/HECExamples
+
+const (
+	hecEventPath = "/services/collector/event"
+	hecRawPath   = "/services/collector/raw"
+	hecAckPath   = "/services/collector/ack"
+
+	hecChannelHeader = "X-Splunk-Request-Channel"
+)
+
+var errHECAckTimeout = errors.New("splunk hec acknowledgement timeout")
+
+type hecConfig struct {
+	Token       string        `config:"token"`
+	Index       string        `config:"index"`
+	Source      string        `config:"source"`
+	Sourcetype  string        `config:"sourcetype"`
+	Host        string        `config:"host"`
+	Raw         bool          `config:"raw"`
+	Channel     string        `config:"channel"`
+	Ack         bool          `config:"ack"`
+	AckInterval time.Duration `config:"ack_interval"`
+	AckTimeout  time.Duration `config:"ack_timeout"`
+}
+
+func (c *hecConfig) Validate() error {
+	if c.AckInterval <= 0 {
+		return fmt.Errorf("splunk_hec.ack_interval must be positive, got %v", c.AckInterval)
+	}
+	if c.AckTimeout < c.AckInterval {
+		return fmt.Errorf("splunk_hec.ack_timeout %v is shorter than ack_interval %v", c.AckTimeout, c.AckInterval)
+	}
+	return nil
+}
+
+type hecResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckId *int64 `json:"ackId"`
+	// InvalidEventNumber is the index of the first invalid event of a batch
+	InvalidEventNumber *int `json:"invalid-event-number"`
+}
+
+type hecAckResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+// headers returns a copy of the configured headers with the hec token and
+// request channel added.
+func (c *hecConfig) headers(headers map[string]string) map[string]string {
+	return mergeHeaders(headers, map[string]string{
+		"Authorization":  "Splunk " + c.Token,
+		hecChannelHeader: c.Channel,
+	})
+}
+
+// rawURL adds the event metadata to the url of the raw endpoint, as raw
+// events can not carry it in the body.
+func (c *hecConfig) rawURL(strUrl string) (string, error) {
+	u, err := url.Parse(strUrl)
+	if err != nil {
+		return "", err
+	}
+
+	values := u.Query()
+	for key, val := range map[string]string{
+		"index":      c.Index,
+		"source":     c.Source,
+		"sourcetype": c.Sourcetype,
+		"host":       c.Host,
+	} {
+		if val != "" {
+			values.Set(key, val)
+		}
+	}
+	u.RawQuery = values.Encode()
+	return u.String(), nil
+}
+
+func makeHECAckURL(strUrl string, channel string) (string, error) {
+	u, err := url.Parse(strUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Path = hecAckPath
+	u.RawQuery = url.Values{"channel": []string{channel}}.Encode()
+	return u.String(), nil
+}
+
+// makeHECEvent builds the hec event envelope: {"time":1,"host":"","source":"","sourcetype":"","index":"","event":{}}
+func makeHECEvent(v beat.Event, msg string, conf *hecConfig, hostname string) eventRaw {
+	ret := make(eventRaw)
+	if conf.Raw {
+		ret[originMsgKey] = json.RawMessage(msg)
+		return ret
+	}
+
+	ret["time"] = json.RawMessage(strconv.FormatFloat(float64(v.Timestamp.UnixMilli())/1000, 'f', 3, 64))
+
+	host := conf.Host
+	if host == "" {
+		host = getEventString(v, "host.name")
+		if host == "" {
+			host = hostname
+		}
+	}
+	if host != "" {
+		ret["host"] = rawString(host)
+	}
+
+	source := conf.Source
+	if source == "" {
+		source = getEventString(v, "log.file.path")
+	}
+	if source != "" {
+		ret["source"] = rawString(source)
+	}
+	if conf.Sourcetype != "" {
+		ret["sourcetype"] = rawString(conf.Sourcetype)
+	}
+	if conf.Index != "" {
+		ret["index"] = rawString(conf.Index)
+	}
+
+	if json.Valid(UnsafeStr2Bytes(msg)) {
+		ret["event"] = json.RawMessage(msg)
+	} else {
+		ret["event"] = rawString(msg)
+	}
+	return ret
+}
+
+func (c *client) checkHECResponse(resp []byte, events int, err error) error {
+	var result hecResponse
+	if jsonErr := json.Unmarshal(resp, &result); jsonErr != nil {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("invalid splunk hec response: %w", jsonErr)
+	}
+	if err != nil {
+		if n := result.InvalidEventNumber; n != nil && *n >= 0 && *n < events && !c.settings.SplunkHEC.Raw {
+			return hecPartialError(*n, events, &result)
+		}
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("splunk hec publish fail, code: %d, text: %s", result.Code, result.Text)
+	}
+
+	if c.hecAckURL == "" || result.AckId == nil {
+		return nil
+	}
+	return c.waitHECAck(*result.AckId)
+}
+
+// hecPartialError reports the events of a batch rejected at invalid: the
+// events before it are indexed, the events after it are not.
+func hecPartialError(invalid, events int, result *hecResponse) *partialError {
+	partial := &partialError{
+		rejected: []int{invalid},
+		msg:      fmt.Sprintf("splunk hec rejected event %d, code: %d, text: %s", invalid, result.Code, result.Text),
+	}
+	for i := invalid + 1; i < events; i++ {
+		partial.retry = append(partial.retry, i)
+	}
+	return partial
+}
+
+// waitHECAck polls the ack endpoint until the indexers acknowledged ackId,
+// the output worker waits up to ack_timeout.
+func (c *client) waitHECAck(ackId int64) error {
+	conf := &c.settings.SplunkHEC
+	body := map[string][]int64{"acks": {ackId}}
+	key := strconv.FormatInt(ackId, 10)
+
+	deadline := time.Now().Add(conf.AckTimeout)
+	ticker := time.NewTicker(conf.AckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return ErrNotConnected
+		case <-ticker.C:
+		}
+
+		_, resp, err := c.conn.RequestURL(http.MethodPost, c.hecAckURL, body)
+		if err != nil {
+			return err
+		}
+
+		var result hecAckResponse
+		if err = json.Unmarshal(resp, &result); err != nil {
+			return fmt.Errorf("invalid splunk hec ack response: %w", err)
+		}
+		if result.Acks[key] {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			c.conn.log.Warnf("splunk hec ack %d not received in %v", ackId, conf.AckTimeout)
+			return errHECAckTimeout
+		}
+	}
+}
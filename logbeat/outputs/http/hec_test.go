@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestHECAck(t *testing.T) {
+	tests := []struct {
+		name string
+		ack  bool
+		// acked is the number of polls before the ack is received, 0 never
+		acked int32
+		// polls is the number of ack requests, at least on timeout
+		polls int32
+		err   error
+	}{
+		{name: "received", ack: true, acked: 2, polls: 2},
+		{name: "timeout", ack: true, polls: 2, err: errHECAckTimeout},
+		{name: "disabled", ack: false, acked: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var polls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case hecEventPath:
+					fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+				case hecAckPath:
+					if r.URL.Query().Get("channel") != "logbeat" {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					var req struct {
+						Acks []int64 `json:"acks"`
+					}
+					_ = json.NewDecoder(r.Body).Decode(&req)
+					n := polls.Add(1)
+					fmt.Fprintf(w, `{"acks":{"%d":%v}}`, req.Acks[0], test.acked > 0 && n >= test.acked)
+				}
+			}))
+			defer srv.Close()
+
+			output := newTestOutput(t, map[string]any{
+				"hosts":                   []string{strings.TrimPrefix(srv.URL, "http://")},
+				"channel":                 "splunk_hec",
+				"batch_mode":              true,
+				"backoff.init":            "1ms",
+				"backoff.max":             "1ms",
+				"splunk_hec.token":        "token",
+				"splunk_hec.channel":      "logbeat",
+				"splunk_hec.ack":          test.ack,
+				"splunk_hec.ack_interval": "5ms",
+				"splunk_hec.ack_timeout":  "15ms",
+			})
+
+			batch := outest.NewBatch(createEvent(1), createEvent(2))
+			err := output.Publish(context.Background(), batch)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if n := polls.Load(); n < test.polls || (test.err == nil && n != test.polls) {
+				t.Fatalf("expected %d ack polls, got %d", test.polls, n)
+			}
+
+			tag := outest.BatchACK
+			if test.err != nil {
+				tag = outest.BatchRetryEvents
+			}
+			if len(batch.Signals) != 1 || batch.Signals[0].Tag != tag {
+				t.Fatalf("expected signal %v, got %v", tag, batch.Signals)
+			}
+		})
+	}
+}
+
+func TestHECConfig(t *testing.T) {
+	for _, settings := range []map[string]any{
+		{"splunk_hec.ack_interval": "0s"},
+		{"splunk_hec.ack_interval": "-1s"},
+		{"splunk_hec.ack_interval": "10s", "splunk_hec.ack_timeout": "5s"},
+	} {
+		settings["channel"] = "splunk_hec"
+		settings["splunk_hec.token"] = "token"
+		conf := defaultConfig
+		if err := config.MustNewConfigFrom(settings).Unpack(&conf); err == nil {
+			t.Errorf("expected an error for %v", settings)
+		}
+	}
+}
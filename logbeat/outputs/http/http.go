@@ -1,11 +1,16 @@
 package http
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/outputs"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 )
 
 func init() {
@@ -22,46 +27,210 @@ func makeHttp(
 	log.Infof("make httpout")
 
 	conf := defaultConfig
-	if err := cfg.Unpack(&conf); err != nil {
+	err := cfg.Unpack(&conf)
+	if err != nil {
 		return outputs.Fail(err)
 	}
 
-	hosts, err := outputs.ReadHostList(cfg)
+	reg := outputRegistry()
+	var group outputs.Group
+	switch {
+	case cfg.HasField("destinations"):
+		group, err = makeDestinations(&conf, beat, observer, cfg, reg, log)
+	case cfg.HasField("routes"):
+		err = errors.New("routes require destinations")
+	default:
+		var clients []outputs.NetworkClient
+		if clients, err = makeClients(&conf, cfg, beat, observer, reg, log); err == nil {
+			group, err = outputs.SuccessNet(conf.Queue, conf.loadBalanced(), conf.BulkMaxSize, conf.MaxRetries, clients)
+		}
+	}
 	if err != nil {
 		return outputs.Fail(err)
 	}
 
+	if conf.Mirror != nil {
+		m, err := newMirror(conf.Mirror, beat, reg, log)
+		if err != nil {
+			for _, cli := range group.Clients {
+				_ = cli.Close()
+			}
+			return outputs.Fail(fmt.Errorf("mirror: %w", err))
+		}
+		group.Clients = m.wrap(group.Clients)
+	}
+	return group, nil
+}
+
+// makeClients creates the output workers of conf, the metrics are registered
+// in reg.
+func makeClients(
+	conf *httpConfig,
+	cfg *config.C,
+	beat beat.Info,
+	observer outputs.Observer,
+	reg *monitoring.Registry,
+	log *logp.Logger,
+) ([]outputs.NetworkClient, error) {
+	var hosts []string
+	var discovered []discoveredHost
+	var err error
+	if conf.Balance.Discovery.enabled() {
+		// a failed resolution is retried by the discovery, the output starts
+		// without hosts
+		discovered, err = newHostResolver(&conf.Balance.Discovery).resolve()
+		if err != nil {
+			log.Errorf("failed to resolve %s: %v", conf.Balance.Discovery.Name, err)
+		}
+		hosts = primaryHosts(discovered)
+	} else if hosts, err = outputs.ReadHostList(cfg); err != nil {
+		return nil, err
+	}
+
 	if proxyURL := conf.Transport.Proxy.URL; proxyURL != nil && !conf.Transport.Proxy.Disable {
 		log.Debugf("breaking down proxy URL. Scheme: '%s', host[:port]: '%s', path: '%s'", proxyURL.Scheme, proxyURL.Host, proxyURL.Path)
 		log.Infof("Using proxy URL: %s", proxyURL)
 	}
 
-	clients := make([]outputs.NetworkClient, len(hosts))
-	for i, host := range hosts {
-		hostURL, err := common.MakeURL(conf.Protocol, "/", host+conf.Path, 0)
+	maxBatchEvents, maxBatchBytes := conf.batchLimits()
+	limiter := newOutputLimiter(&conf.RateLimit, &conf.Concurrency, conf.workers(hosts), reg)
+	metrics := newOutputMetrics(reg)
+	drops := newDropStats(reg, log)
+	debug, err := newDebugCapture(&conf.Debug)
+	if err != nil {
+		return nil, err
+	}
+	spool, err := newSpool(&conf.Spool, conf.Idempotency.Header, reg, drops, log)
+	if err != nil {
+		return nil, err
+	}
+	breakers := newCircuitBreakers(&conf.CircuitBreaker, reg, log)
+
+	newHostClient := func(host string) (*client, error) {
+		hostURL, err := common.MakeURL(conf.Protocol, "/", host+conf.channelPath(), 0)
 		if err != nil {
 			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
-			return outputs.Fail(err)
+			return nil, err
 		}
 
-		var cli outputs.NetworkClient
-		cli, err = newClient(clientSettings{
+		return newClient(clientSettings{
 			URL:              hostURL,
 			Observer:         observer,
 			Username:         conf.Username,
 			Password:         conf.Password,
+			PasswordFile:     conf.PasswordFile,
+			BearerToken:      conf.BearerToken,
+			BearerTokenFile:  conf.BearerTokenFile,
+			OAuth2:           conf.OAuth2,
 			CompressionLevel: conf.CompressionLevel,
 			BatchMode:        conf.BatchMode,
+			MaxBatchEvents:   maxBatchEvents,
+			MaxBatchBytes:    maxBatchBytes,
 			Headers:          conf.Headers,
+			HeaderFiles:      conf.HeaderFiles,
+			ParamFiles:       conf.ParamFiles,
 			Channel:          conf.Channel,
 			AppId:            conf.AppId,
+			AppIdFile:        conf.AppIdFile,
+			SplunkHEC:        conf.SplunkHEC,
+			Bulk:             conf.Bulk,
+			OTLP:             conf.OTLP,
+			Datadog:          conf.Datadog,
+			NewRelic:         conf.NewRelic,
+			ClickHouse:       conf.ClickHouse,
+			Webhook:          conf.Webhook,
+			Signer:           conf.Signer,
+			Idempotency:      conf.Idempotency,
+			RetryStatuses:    conf.RetryStatuses,
+			Backoff:          conf.Backoff,
+			AttemptTimeout:   conf.AttemptTimeout,
+			Beat:             beat,
+			Transport:        conf.Transport,
+			Limiter:          limiter,
+			Metrics:          metrics,
+			Drops:            drops,
+			Debug:            debug,
+			Spool:            spool,
+			Breakers:         breakers,
 		})
+	}
 
+	if conf.Balance.enabled() {
+		return makeBalancedClients(conf, hosts, discovered, newHostClient, breakers, log)
+	}
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		cli, err := newHostClient(host)
 		if err != nil {
-			return outputs.Fail(err)
+			return nil, err
 		}
-
 		clients[i] = cli
 	}
-	return outputs.SuccessNet(conf.Queue, conf.LoadBalance, conf.BulkMaxSize, maxRetries, clients)
+	return clients, nil
+}
+
+// makeBalancedClients creates the workers sharing the host pool, one worker
+// per primary host when loadbalance is enabled. The discovered hosts are kept
+// up to date by re-resolving them every discovery.interval, the SRV targets of
+// the lowest priority are the primary hosts and the others the backup hosts.
+func makeBalancedClients(
+	conf *httpConfig,
+	hosts []string,
+	discovered []discoveredHost,
+	newHostClient func(host string) (*client, error),
+	breakers *circuitBreakers,
+	log *logp.Logger,
+) ([]outputs.NetworkClient, error) {
+	discovery := &conf.Balance.Discovery
+
+	var pool *hostPool
+	var err error
+	if discovery.enabled() {
+		pool, err = newHostPool(&conf.Balance, conf.Protocol, conf.Channel, nil, breakers, log)
+		if err != nil {
+			return nil, err
+		}
+		pool.sync(conf.Protocol, discovered)
+		pool.startDiscovery(conf.Protocol, newHostResolver(discovery))
+	} else {
+		pool, err = newHostPool(&conf.Balance, conf.Protocol, conf.Channel, hosts, breakers, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Balance.HealthCheck.Enabled {
+		httpClient, err := conf.Transport.Client(
+			httpcommon.WithLogger(log),
+			httpcommon.WithKeepaliveSettings{IdleConnTimeout: idleConnectTimeout},
+		)
+		if err != nil {
+			return nil, err
+		}
+		pool.startHealthCheck(httpClient)
+	}
+
+	clients := make([]outputs.NetworkClient, conf.workers(hosts))
+	for i := range clients {
+		clients[i] = newBalancedClient(pool, newHostClient, &conf.Backoff)
+	}
+	return clients, nil
+}
+
+// loadBalanced reports whether every client is an output worker, instead of
+// failing over to the next host. The balanced hosts are picked by every worker.
+func (c *httpConfig) loadBalanced() bool {
+	return c.LoadBalance || c.Balance.enabled()
+}
+
+// workers returns the number of output workers sending concurrently.
+func (c *httpConfig) workers(hosts []string) int {
+	if c.Balance.Discovery.enabled() && c.Balance.Discovery.Workers > 0 {
+		return c.Balance.Discovery.Workers
+	}
+	if c.LoadBalance && len(hosts) > 0 {
+		return len(hosts)
+	}
+	return 1
 }
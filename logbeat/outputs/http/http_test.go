@@ -0,0 +1,354 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/idxmgmt"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"logbeat/outputs/http/fakeserver"
+)
+
+func TestPublish(t *testing.T) {
+	srv := fakeserver.New(fakeserver.OpenObserve)
+	defer srv.Close()
+	srv.Username, srv.Password = "root@example.com", "secret"
+
+	cfg := map[string]any{
+		"protocol":   "http",
+		"hosts":      []string{srv.Host()},
+		"path":       fakeserver.OpenObservePath,
+		"username":   "root@example.com",
+		"password":   "secret",
+		"batch_mode": true,
+		"channel":    "openobserve",
+	}
+
+	testPublishList(t, cfg)
+
+	events := srv.Events()
+	if len(events) != 20 || srv.Requests() != 2 {
+		t.Fatalf("expected 20 events in 2 requests, got %d in %d", len(events), srv.Requests())
+	}
+	for i, e := range events {
+		if expected := fmt.Sprintf(`{"log":"test %d"}`, i+1); string(e) != expected {
+			t.Errorf("expected %s, got %s", expected, e)
+		}
+	}
+}
+
+func TestPublishChannels(t *testing.T) {
+	taEvent := func(id int) beat.Event {
+		return beat.Event{Fields: mapstr.M{
+			"message": fmt.Sprintf(`{"#type":"track","#event_name":"login","#account_id":"%d"}`, id),
+		}}
+	}
+	saEvent := func(id int) beat.Event {
+		return beat.Event{Fields: mapstr.M{
+			"message": fmt.Sprintf(`{"type":"track","event":"login","distinct_id":"%d"}`, id),
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		kind     fakeserver.Kind
+		cfg      map[string]any
+		event    func(id int) beat.Event
+		requests int
+	}{
+		{
+			name:     "openobserve gzip",
+			kind:     fakeserver.OpenObserve,
+			cfg:      map[string]any{"path": fakeserver.OpenObservePath, "compression_level": 5, "batch_mode": true},
+			event:    createEvent,
+			requests: 1,
+		},
+		{
+			name:     "openobserve single",
+			kind:     fakeserver.OpenObserve,
+			cfg:      map[string]any{"path": fakeserver.OpenObservePath},
+			event:    createEvent,
+			requests: 3,
+		},
+		{
+			name:     "thinkingdata batch",
+			kind:     fakeserver.ThinkingData,
+			cfg:      map[string]any{"path": fakeserver.ThinkingDataPath, "app_id": "app", "batch_mode": true},
+			event:    taEvent,
+			requests: 1,
+		},
+		{
+			name:     "thinkingdata single",
+			kind:     fakeserver.ThinkingData,
+			cfg:      map[string]any{"path": fakeserver.ThinkingDataPath, "app_id": "app"},
+			event:    taEvent,
+			requests: 3,
+		},
+		{
+			name:     "sensorsdata batch",
+			kind:     fakeserver.SensorsData,
+			cfg:      map[string]any{"path": fakeserver.SensorsDataPath + "?project=test&token=token", "batch_mode": true},
+			event:    saEvent,
+			requests: 1,
+		},
+		{
+			name:     "sensorsdata single",
+			kind:     fakeserver.SensorsData,
+			cfg:      map[string]any{"path": fakeserver.SensorsDataPath + "?project=test&token=token"},
+			event:    saEvent,
+			requests: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := fakeserver.New(test.kind)
+			defer srv.Close()
+			srv.AppId, srv.Token = "app", "token"
+
+			test.cfg["hosts"] = []string{srv.Host()}
+			test.cfg["channel"] = string(test.kind)
+			output := newTestOutput(t, test.cfg)
+
+			batch := outest.NewBatch(test.event(1), test.event(2), test.event(3))
+			if err := output.Publish(context.Background(), batch); err != nil {
+				t.Fatal(err)
+			}
+			if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchACK {
+				t.Fatalf("expected the batch to be acked, got %v", batch.Signals)
+			}
+			if n := srv.Requests(); n != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, n)
+			}
+
+			events := srv.Events()
+			if len(events) != 3 {
+				t.Fatalf("expected 3 events, got %d", len(events))
+			}
+			for i, e := range events {
+				msg, _ := test.event(i + 1).Fields.GetValue("message")
+				if test.kind == fakeserver.OpenObserve {
+					b, _ := json.Marshal(map[string]any{"log": msg})
+					msg = string(b)
+				}
+				if string(e) != msg {
+					t.Errorf("expected %s, got %s", msg, e)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(srv *fakeserver.Server)
+		cfg   map[string]any
+		// the events are dropped when err is empty
+		err string
+	}{
+		{
+			name:  "server error",
+			setup: func(srv *fakeserver.Server) { srv.Fail(http.StatusInternalServerError, 1) },
+			err:   "500",
+		},
+		{
+			name:  "too many requests",
+			setup: func(srv *fakeserver.Server) { srv.Fail(http.StatusTooManyRequests, 1) },
+			err:   "429",
+		},
+		{
+			name:  "request timeout",
+			setup: func(srv *fakeserver.Server) { srv.Fail(http.StatusRequestTimeout, 1) },
+			err:   "408",
+		},
+		{
+			name:  "bad request",
+			setup: func(srv *fakeserver.Server) { srv.Fail(http.StatusBadRequest, 1) },
+		},
+		{
+			name:  "request too large",
+			setup: func(srv *fakeserver.Server) { srv.MaxBodyBytes = 16 },
+		},
+		{
+			name:  "unauthorized",
+			setup: func(srv *fakeserver.Server) { srv.Username = "other" },
+		},
+		{
+			name:  "unauthorized retried",
+			setup: func(srv *fakeserver.Server) { srv.Username = "other" },
+			cfg:   map[string]any{"retry_statuses": []int{401, 403}},
+			err:   "401",
+		},
+		{
+			name:  "slow server",
+			setup: func(srv *fakeserver.Server) { srv.SetLatency(time.Second) },
+			cfg:   map[string]any{"attempt_timeout": "50ms"},
+			err:   "deadline exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := fakeserver.New(fakeserver.OpenObserve)
+			defer srv.Close()
+			test.setup(srv)
+
+			cfg := map[string]any{
+				"hosts":        []string{srv.Host()},
+				"path":         fakeserver.OpenObservePath,
+				"channel":      "openobserve",
+				"batch_mode":   true,
+				"backoff.init": "1ms",
+				"backoff.max":  "1ms",
+			}
+			for k, v := range test.cfg {
+				cfg[k] = v
+			}
+			output := newTestOutput(t, cfg)
+
+			batch := outest.NewBatch(createEvent(1), createEvent(2), createEvent(3))
+			err := output.Publish(context.Background(), batch)
+			if test.err == "" {
+				// rejected permanently
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchACK {
+					t.Fatalf("expected the batch to be acked, got %v", batch.Signals)
+				}
+				if n := output.(*client).settings.Drops.counters[dropRejected].Get(); n != 3 {
+					t.Fatalf("expected 3 rejected events, got %d", n)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchRetryEvents || len(batch.Signals[0].Events) != 3 {
+					t.Fatalf("expected the events to be retried, got %v", batch.Signals)
+				}
+			}
+			if len(srv.Events()) != 0 {
+				t.Fatalf("unexpected events accepted: %v", srv.Events())
+			}
+		})
+	}
+}
+
+func TestPublishRetry(t *testing.T) {
+	srv := fakeserver.New(fakeserver.OpenObserve)
+	defer srv.Close()
+	srv.Fail(http.StatusServiceUnavailable, 1)
+
+	out, err := makeHttp(nil, beat.Info{}, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts":        []string{srv.Host()},
+		"path":         fakeserver.OpenObservePath,
+		"channel":      "openobserve",
+		"backoff.init": "1ms",
+		"backoff.max":  "1ms",
+		"max_retries":  5,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Retry != 5 || len(out.Clients) != 1 {
+		t.Fatalf("unexpected output group: %+v", out)
+	}
+	output := out.Clients[0].(outputs.NetworkClient)
+	defer output.Close()
+	if err = output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events of a failed batch are published again by the pipeline
+	batch := outest.NewBatch(createEvent(1), createEvent(2))
+	if err = output.Publish(context.Background(), batch); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	retry := &eventsBatch{Batch: outest.NewBatch(), events: batch.Signals[0].Events}
+	if err = output.Publish(context.Background(), retry); err != nil {
+		t.Fatal(err)
+	}
+	if len(retry.Signals) != 1 || retry.Signals[0].Tag != outest.BatchACK {
+		t.Fatalf("expected the retried batch to be acked, got %v", retry.Signals)
+	}
+	if n := len(srv.Events()); n != 2 {
+		t.Fatalf("expected 2 events, got %d", n)
+	}
+}
+
+func testPublishList(t *testing.T, cfg map[string]any) {
+	batches := 2
+	batchSize := 10
+
+	output := newTestOutput(t, cfg)
+	err := sendTestEvents(output, batches, batchSize)
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+}
+
+func newTestOutput(t *testing.T, cfg map[string]any) outputs.Client {
+	conf, err := config.NewConfigFrom(cfg)
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	info := beat.Info{Beat: "libbeat"}
+	// disable ILM if using specified index name
+	im, _ := idxmgmt.DefaultSupport(nil, info, config.MustNewConfigFrom(map[string]any{"setup.ilm.enabled": "false"}))
+
+	out, err := makeHttp(im, info, outputs.NewNilObserver(), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize http output: %v", err)
+	}
+
+	cli := out.Clients[0].(outputs.NetworkClient)
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("Failed to connect to http host: %v", err)
+	}
+	t.Cleanup(func() { _ = cli.Close() })
+
+	return cli
+}
+
+func sendTestEvents(out outputs.Client, batches, N int) error {
+	cnt := 1
+	for b := 0; b < batches; b++ {
+		events := make([]beat.Event, N)
+		for n := range events {
+			events[n] = createEvent(cnt)
+			cnt++
+		}
+
+		batch := outest.NewBatch(events...)
+		err := out.Publish(context.Background(), batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createEvent(id int) beat.Event {
+	return beat.Event{
+		Timestamp: time.Now(),
+		Meta: mapstr.M{
+			"test": "test-MetaValue",
+		},
+		Fields: mapstr.M{
+			"message": fmt.Sprintf("test %d", id),
+		},
+	}
+}
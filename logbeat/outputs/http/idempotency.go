@@ -0,0 +1,74 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+// eventIDNamespace is the namespace of the name based uuids of the events.
+var eventIDNamespace = uuid.MustParse("6f1c2b1e-6d0e-4c5a-9a43-7d0c1f3b2e58")
+
+// idempotencyConfig sets the keys sent with the requests, so the retries of a
+// request can be de-duplicated by the backend.
+type idempotencyConfig struct {
+	// Header is the header of the request key, e.g. Idempotency-Key.
+	Header string `config:"header"`
+	// EventIDs sets an id per event: #uuid for shushu, _id for es_bulk.
+	EventIDs bool `config:"event_ids"`
+}
+
+// eventKey hashes the source and content of an event. The events read from
+// files are identified by their file and offset, the others by timestamp.
+func eventKey(h hash.Hash, v beat.Event) []byte {
+	h.Reset()
+	path, _ := v.Fields.GetValue("log.file.path")
+	offset, err := v.Fields.GetValue("log.offset")
+	if err == nil {
+		fmt.Fprintf(h, "%v\n%v\n", path, offset)
+	} else {
+		fmt.Fprintf(h, "%d\n", v.Timestamp.UnixNano())
+	}
+	msg, _ := v.Fields.GetValue("message")
+	fmt.Fprintf(h, "%v", msg)
+	return h.Sum(nil)
+}
+
+// eventID returns a stable uuid of the event.
+func eventID(v beat.Event) string {
+	return uuid.NewSHA1(eventIDNamespace, eventKey(sha256.New(), v)).String()
+}
+
+// requestKey returns the key of a request of events, the same events give the
+// same key when the request is retried.
+func requestKey(data []publisher.Event) string {
+	h := sha256.New()
+	eh := sha256.New()
+	for _, e := range data {
+		h.Write(eventKey(eh, e.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withEventUUID adds the #uuid property to a json object message of
+// ThinkingData, unless it is set.
+func withEventUUID(msg string, v beat.Event) string {
+	trimmed := strings.TrimSpace(msg)
+	if !strings.HasPrefix(trimmed, "{") || strings.Contains(trimmed, `"#uuid"`) {
+		return msg
+	}
+
+	field := `"#uuid":"` + eventID(v) + `"`
+	rest := strings.TrimSpace(trimmed[1:])
+	if strings.HasPrefix(rest, "}") {
+		return "{" + field + rest
+	}
+	return "{" + field + "," + rest
+}
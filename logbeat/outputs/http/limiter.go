@@ -0,0 +1,240 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// latencyWeight is the weight of a request in the average latency.
+const latencyWeight = 0.1
+
+type rateLimitConfig struct {
+	Events float64 `config:"events" validate:"min=0"` // events per second
+	Bytes  float64 `config:"bytes" validate:"min=0"`  // uncompressed bytes per second
+}
+
+type concurrencyConfig struct {
+	// Max is the number of concurrent requests, defaults to the number of
+	// workers.
+	Max int `config:"max" validate:"min=0"`
+	// Adaptive adjusts the limit between Min and Max: the limit is increased
+	// by one per round trip and decreased by DecreaseRatio when the server
+	// answers 429 or 503, or the latency exceeds LatencyRatio times the average.
+	Adaptive      bool    `config:"adaptive"`
+	Min           int     `config:"min" validate:"min=1"`
+	LatencyRatio  float64 `config:"latency_ratio"`
+	DecreaseRatio float64 `config:"decrease_ratio"`
+}
+
+var defaultConcurrencyConfig = concurrencyConfig{
+	Min:           1,
+	LatencyRatio:  2,
+	DecreaseRatio: 0.5,
+}
+
+func (c *concurrencyConfig) Validate() error {
+	if c.Max > 0 && c.Max < c.Min {
+		return errors.New("concurrency.max must not be less than concurrency.min")
+	}
+	if c.LatencyRatio <= 1 {
+		return errors.New("concurrency.latency_ratio must be greater than 1")
+	}
+	if c.DecreaseRatio <= 0 || c.DecreaseRatio >= 1 {
+		return errors.New("concurrency.decrease_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// outputLimiter limits the requests of all clients of the output.
+type outputLimiter struct {
+	events      *rate.Limiter
+	bytes       *rate.Limiter
+	concurrency *concurrencyLimiter
+
+	throttled *monitoring.Int
+	waitTime  *monitoring.Int
+}
+
+// newOutputLimiter returns nil when no limit is configured.
+func newOutputLimiter(rl *rateLimitConfig, cc *concurrencyConfig, workers int, reg *monitoring.Registry) *outputLimiter {
+	if rl.Events == 0 && rl.Bytes == 0 && cc.Max == 0 && !cc.Adaptive {
+		return nil
+	}
+
+	l := &outputLimiter{}
+	if rl.Events > 0 || rl.Bytes > 0 {
+		rateReg := subRegistry(reg, "rate_limit")
+		l.throttled = monitoring.NewInt(rateReg, "throttled")
+		l.waitTime = monitoring.NewInt(rateReg, "wait_ms")
+	}
+	if rl.Events > 0 {
+		l.events = rate.NewLimiter(rate.Limit(rl.Events), burst(rl.Events))
+	}
+	if rl.Bytes > 0 {
+		l.bytes = rate.NewLimiter(rate.Limit(rl.Bytes), burst(rl.Bytes))
+	}
+	if cc.Max > 0 || cc.Adaptive {
+		l.concurrency = newConcurrencyLimiter(cc, workers, subRegistry(reg, "concurrency"))
+	}
+	return l
+}
+
+// burst allows a second worth of tokens at once.
+func burst(perSecond float64) int {
+	if perSecond < 1 {
+		return 1
+	}
+	return int(perSecond)
+}
+
+// acquire waits until a request of events and bytes is allowed. The returned
+// function must be called with the response status once the request is done.
+func (l *outputLimiter) acquire(ctx context.Context, events, bytes int) (func(status int), error) {
+	start := time.Now()
+	if err := waitN(ctx, l.events, events); err != nil {
+		return nil, err
+	}
+	if err := waitN(ctx, l.bytes, bytes); err != nil {
+		return nil, err
+	}
+	if l.throttled != nil {
+		if wait := time.Since(start); wait >= time.Millisecond {
+			l.throttled.Inc()
+			l.waitTime.Add(wait.Milliseconds())
+		}
+	}
+
+	if l.concurrency == nil {
+		return func(int) {}, nil
+	}
+	if err := l.concurrency.acquire(ctx); err != nil {
+		return nil, err
+	}
+	sent := time.Now()
+	return func(status int) {
+		overloaded := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+		l.concurrency.release(time.Since(sent), overloaded)
+	}, nil
+}
+
+// waitN takes n tokens from limiter, in steps of at most the burst size.
+func waitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		step := n
+		if b := limiter.Burst(); step > b {
+			step = b
+		}
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// concurrencyLimiter limits the number of requests in flight, the limit is
+// adjusted by additive increase and multiplicative decrease when adaptive.
+type concurrencyLimiter struct {
+	conf *concurrencyConfig
+	min  float64
+	max  float64
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	latency  time.Duration
+	wake     chan struct{}
+
+	limitGauge    *monitoring.Int
+	inflightGauge *monitoring.Int
+	decreases     *monitoring.Int
+}
+
+func newConcurrencyLimiter(conf *concurrencyConfig, workers int, reg *monitoring.Registry) *concurrencyLimiter {
+	upper := conf.Max
+	if upper == 0 {
+		upper = workers
+	}
+	lower := conf.Min
+	if !conf.Adaptive || lower > upper {
+		lower = upper
+	}
+
+	l := &concurrencyLimiter{
+		conf:          conf,
+		min:           float64(lower),
+		max:           float64(upper),
+		limit:         float64(upper),
+		wake:          make(chan struct{}),
+		limitGauge:    monitoring.NewInt(reg, "limit"),
+		inflightGauge: monitoring.NewInt(reg, "inflight"),
+		decreases:     monitoring.NewInt(reg, "decreases"),
+	}
+	l.limitGauge.Set(int64(upper))
+	return l
+}
+
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.inflightGauge.Set(int64(l.inflight))
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (l *concurrencyLimiter) release(latency time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	l.inflightGauge.Set(int64(l.inflight))
+
+	if l.conf.Adaptive {
+		slow := l.latency > 0 && float64(latency) > l.conf.LatencyRatio*float64(l.latency)
+		if overloaded || slow {
+			l.limit *= l.conf.DecreaseRatio
+			if l.limit < l.min {
+				l.limit = l.min
+			}
+			l.decreases.Inc()
+		} else {
+			l.limit += 1 / l.limit
+			if l.limit > l.max {
+				l.limit = l.max
+			}
+		}
+		if !overloaded {
+			if l.latency == 0 {
+				l.latency = latency
+			} else {
+				l.latency += time.Duration(latencyWeight * float64(latency-l.latency))
+			}
+		}
+		l.limitGauge.Set(int64(l.limit))
+	}
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := newOutputLimiter(&rateLimitConfig{Events: 1000, Bytes: 10000}, &concurrencyConfig{}, 1, monitoring.NewRegistry())
+
+	// a second worth of tokens is taken at once
+	done, err := l.acquire(context.Background(), 1000, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(http.StatusOK)
+	if n := l.throttled.Get(); n != 0 {
+		t.Fatalf("expected no throttled request, got %d", n)
+	}
+
+	// the bucket is empty, the next events wait for it to refill
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = l.acquire(ctx, 500, 100); err == nil {
+		t.Fatal("expected the events to exceed the deadline")
+	}
+
+	// the requests larger than the burst are taken in steps
+	start := time.Now()
+	if _, err = l.acquire(context.Background(), 1, 12000); err != nil {
+		t.Fatal(err)
+	}
+	if wait := time.Since(start); wait < 150*time.Millisecond {
+		t.Fatalf("expected the bytes to wait for the bucket, waited %v", wait)
+	}
+	if n := l.throttled.Get(); n != 1 {
+		t.Fatalf("expected 1 throttled request, got %d", n)
+	}
+
+	if newOutputLimiter(&rateLimitConfig{}, &concurrencyConfig{}, 1, monitoring.NewRegistry()) != nil {
+		t.Fatal("expected no limiter without limits")
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	l := newOutputLimiter(&rateLimitConfig{}, &concurrencyConfig{Max: 2}, 4, monitoring.NewRegistry())
+
+	var releases []func(int)
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(context.Background(), 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// the third request waits for a request in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, 1, 1); err == nil {
+		t.Fatal("expected the third request to wait")
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(context.Background(), 1, 1)
+		acquired <- err
+	}()
+	releases[0](http.StatusOK)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the third request to be sent after a release")
+	}
+	if n := l.concurrency.inflightGauge.Get(); n != 2 {
+		t.Fatalf("expected 2 requests in flight, got %d", n)
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	conf := defaultConcurrencyConfig
+	conf.Max = 4
+	conf.Adaptive = true
+	l := newConcurrencyLimiter(&conf, 1, monitoring.NewRegistry())
+
+	request := func(latency time.Duration, overloaded bool) {
+		t.Helper()
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		l.release(latency, overloaded)
+	}
+	limit := func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.limit
+	}
+
+	// the limit is halved on overload down to min, increased per round trip
+	request(10*time.Millisecond, false)
+	request(10*time.Millisecond, true)
+	request(10*time.Millisecond, true)
+	request(10*time.Millisecond, true)
+	if got := limit(); got != 1 {
+		t.Fatalf("expected the limit to be min, got %v", got)
+	}
+	request(10*time.Millisecond, false)
+	if got := limit(); got != 2 {
+		t.Fatalf("expected the limit to be increased to 2, got %v", got)
+	}
+
+	// a latency above latency_ratio times the average decreases the limit
+	request(50*time.Millisecond, false)
+	if got := limit(); got != 1 {
+		t.Fatalf("expected the slow request to decrease the limit, got %v", got)
+	}
+	if n := l.decreases.Get(); n != 4 {
+		t.Fatalf("expected 4 decreases, got %d", n)
+	}
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/monitoring/adapter"
+)
+
+const (
+	metricsRegistryName = "libbeat.output.http"
+	latencySampleSize   = 1024
+	defaultChannelName  = "json"
+)
+
+// outputRegistry returns the empty registry of the http output metrics,
+// reported by the stats endpoint. The metrics of a previous output are
+// cleared when the output is reloaded.
+func outputRegistry() *monitoring.Registry {
+	if reg := monitoring.Default.GetRegistry(metricsRegistryName); reg != nil {
+		_ = reg.Clear()
+		return reg
+	}
+	return monitoring.Default.NewRegistry(metricsRegistryName)
+}
+
+// subRegistry returns the registry name of parent, creating it if needed.
+func subRegistry(parent *monitoring.Registry, name string) *monitoring.Registry {
+	if reg := parent.GetRegistry(name); reg != nil {
+		return reg
+	}
+	return parent.NewRegistry(name)
+}
+
+// outputMetrics holds the request metrics of the output by channel and host,
+// registered as <channel>.<host>.
+type outputMetrics struct {
+	reg *monitoring.Registry
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+}
+
+func newOutputMetrics(reg *monitoring.Registry) *outputMetrics {
+	return &outputMetrics{
+		reg:   reg,
+		hosts: make(map[string]*hostStats),
+	}
+}
+
+// host returns the stats of the host of rawURL, shared by all clients of the
+// host.
+func (m *outputMetrics) host(channel, rawURL string) *hostStats {
+	if m == nil {
+		return nil
+	}
+	name := hostRegistryName(channel, rawURL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stats, ok := m.hosts[name]; ok {
+		return stats
+	}
+
+	reg := subRegistry(m.reg, name)
+	stats := &hostStats{
+		requests:          monitoring.NewInt(reg, "requests.total"),
+		errors:            monitoring.NewInt(reg, "requests.errors"),
+		events:            monitoring.NewInt(reg, "events"),
+		bytesSent:         monitoring.NewInt(reg, "bytes.sent"),
+		bytesUncompressed: monitoring.NewInt(reg, "bytes.uncompressed"),
+		statusReg:         subRegistry(reg, "status"),
+		status:            make(map[int]*monitoring.Int),
+		latency:           metrics.NewUniformSample(latencySampleSize),
+	}
+	adapter.GetGoMetrics(reg, "latency", adapter.Accept).
+		Register("histogram", metrics.NewHistogram(stats.latency))
+	m.hosts[name] = stats
+	return stats
+}
+
+// hostRegistryName returns the registry name of the metrics of the host of
+// rawURL: <channel>.<host>.
+func hostRegistryName(channel, rawURL string) string {
+	if channel == "" {
+		channel = defaultChannelName
+	}
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	// dots separate the registry names
+	return channel + "." + strings.Replace(host, ".", "_", -1)
+}
+
+// hostStats are the metrics of the requests sent to a host. The methods are
+// no-ops on nil stats.
+type hostStats struct {
+	requests          *monitoring.Int
+	errors            *monitoring.Int
+	events            *monitoring.Int
+	bytesSent         *monitoring.Int
+	bytesUncompressed *monitoring.Int
+
+	mu        sync.Mutex
+	statusReg *monitoring.Registry
+	status    map[int]*monitoring.Int
+
+	// latency of the requests in milliseconds
+	latency metrics.Sample
+}
+
+// request records a request, status is 0 when no response is received.
+func (s *hostStats) request(status int, latency time.Duration, sent int64) {
+	if s == nil {
+		return
+	}
+
+	s.requests.Inc()
+	s.latency.Update(latency.Milliseconds())
+	if sent > 0 {
+		s.bytesSent.Add(sent)
+	}
+	if status == 0 {
+		s.errors.Inc()
+		return
+	}
+
+	s.mu.Lock()
+	counter, ok := s.status[status]
+	if !ok {
+		counter = monitoring.NewInt(s.statusReg, strconv.Itoa(status))
+		s.status[status] = counter
+	}
+	s.mu.Unlock()
+	counter.Inc()
+}
+
+// sent records the events of a request and their uncompressed json size.
+func (s *hostStats) sent(events, bytes int) {
+	if s == nil {
+		return
+	}
+	s.events.Add(int64(events))
+	s.bytesUncompressed.Add(int64(bytes))
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	mirrorName = "mirror"
+	// mirroredKey marks the events in the event cache, so the batches retried
+	// by the output are not mirrored again.
+	mirroredKey = "httpout.mirrored"
+)
+
+// mirror sends a copy of the published events to a shadow destination, e.g.
+// to dual-write while migrating to another backend. The shadow has its own
+// queue, workers and metrics under mirror, the output never waits for it:
+// the batches are skipped when its queue is full, and dropped after its
+// max_retries.
+type mirror struct {
+	dest *destination
+	log  *logp.Logger
+	// refs is the number of output workers not closed yet
+	refs atomic.Int32
+}
+
+func newMirror(cfg *config.C, beat beat.Info, reg *monitoring.Registry, log *logp.Logger) (*mirror, error) {
+	// the name is set on a copy, the mirror block of the user is unchanged
+	cfg, err := config.NewConfigFrom(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.SetString("name", -1, mirrorName); err != nil {
+		return nil, err
+	}
+	// the shadow deliveries are not reported as the ones of the output
+	d, err := newDestination(cfg, beat, outputs.NewNilObserver(), reg, log)
+	if err != nil {
+		return nil, err
+	}
+	d.start()
+	return &mirror{dest: d, log: log}, nil
+}
+
+// wrap mirrors the batches of the output workers.
+func (m *mirror) wrap(clients []outputs.Client) []outputs.Client {
+	ret := make([]outputs.Client, len(clients))
+	for i, cli := range clients {
+		ret[i] = &mirrorClient{Client: cli, mirror: m}
+	}
+	m.refs.Store(int32(len(clients)))
+	return ret
+}
+
+// offer queues the events not mirrored yet which match the condition of the
+// mirror.
+func (m *mirror) offer(events []publisher.Event) {
+	var selected []publisher.Event
+	for i := range events {
+		if mirrored, _ := events[i].Cache.GetValue(mirroredKey); mirrored != nil {
+			continue
+		}
+		_, _ = events[i].Cache.Put(mirroredKey, true)
+		if m.dest.match(&events[i].Content) {
+			selected = append(selected, events[i])
+		}
+	}
+
+	for _, b := range m.dest.split(nil, selected) {
+		n := int64(len(b.events))
+		if m.dest.queue.offer(b) {
+			m.dest.routed.Add(n)
+		} else {
+			m.dest.skipped.Add(n)
+			m.log.Debugf("mirror queue is full, skipped %d events", n)
+		}
+	}
+}
+
+func (m *mirror) release() error {
+	if m.refs.Add(-1) > 0 {
+		return nil
+	}
+	return m.dest.close()
+}
+
+// mirrorClient is an output worker whose batches are mirrored.
+type mirrorClient struct {
+	outputs.Client
+	mirror *mirror
+}
+
+func (c *mirrorClient) Connect() error {
+	if cli, ok := c.Client.(outputs.NetworkClient); ok {
+		return cli.Connect()
+	}
+	return nil
+}
+
+func (c *mirrorClient) Close() error {
+	return errors.Join(c.Client.Close(), c.mirror.release())
+}
+
+func (c *mirrorClient) Publish(ctx context.Context, batch publisher.Batch) error {
+	c.mirror.offer(batch.Events())
+	return c.Client.Publish(ctx, batch)
+}
+
+func (c *mirrorClient) String() string {
+	return c.Client.String() + "+mirror"
+}
+
+var _ outputs.NetworkClient = (*mirrorClient)(nil)
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// new relic referrer: https://docs.newrelic.com/docs/logs/log-api/introduction-log-api/
+
+const (
+	newRelicLogsPath = "/log/v1"
+
+	newRelicMaxBatchEvents = 1000
+	newRelicMaxBatchBytes  = 1000 * 1000
+)
+
+type newRelicConfig struct {
+	APIKey     string            `config:"api_key"`
+	LicenseKey string            `config:"license_key"`
+	Service    string            `config:"service"`
+	Attributes map[string]string `config:"attributes"`
+}
+
+func (c *newRelicConfig) headers(headers map[string]string) map[string]string {
+	if c.APIKey != "" {
+		return mergeHeaders(headers, map[string]string{"Api-Key": c.APIKey})
+	}
+	return mergeHeaders(headers, map[string]string{"X-License-Key": c.LicenseKey})
+}
+
+// common returns the attributes shared by all logs of a request.
+func (c *newRelicConfig) common(info beat.Info) map[string]string {
+	ret := map[string]string{
+		"hostname": info.Hostname,
+	}
+	if c.Service != "" {
+		ret["service.name"] = c.Service
+	}
+	for key, val := range c.Attributes {
+		ret[key] = val
+	}
+	return ret
+}
+
+// makeNewRelicLog: {"timestamp":1,"message":"xxx","attributes":{}}
+func makeNewRelicLog(v beat.Event, msg string) eventRaw {
+	ret := make(eventRaw)
+	ret["timestamp"] = json.RawMessage(strconv.FormatInt(v.Timestamp.UnixMilli(), 10))
+	ret["message"] = rawString(msg)
+
+	attrs := make(map[string]any)
+	if path := getEventString(v, "log.file.path"); path != "" {
+		attrs["filePath"] = path
+	}
+	if host := getEventString(v, "host.name"); host != "" {
+		attrs["hostname"] = host
+	}
+	if _, level := getSeverity(msg); level != "" {
+		attrs["level"] = level
+	}
+	if traceId := getTraceId(msg); traceId != "" {
+		attrs["trace.id"] = traceId
+	}
+	if len(attrs) > 0 {
+		ret["attributes"], _ = json.Marshal(attrs)
+	}
+	return ret
+}
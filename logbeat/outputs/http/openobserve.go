@@ -0,0 +1,46 @@
+package http
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// openobserve referrer: https://openobserve.ai/docs/api/ingestion/logs/json/
+
+type openObserveResponse struct {
+	Code   int                 `json:"code"`
+	Status []openObserveStream `json:"status"`
+}
+
+type openObserveStream struct {
+	Name       string `json:"name"`
+	Successful int    `json:"successful"`
+	Failed     int    `json:"failed"`
+	Error      string `json:"error"`
+}
+
+// checkOpenObserveResponse drops the records OpenObserve failed to ingest,
+// e.g. not matching the stream schema. The response only has their number by
+// stream. A response without the status is a success.
+func checkOpenObserveResponse(resp []byte) error {
+	var result openObserveResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil
+	}
+
+	failed := 0
+	var msgs []string
+	for _, stream := range result.Status {
+		if stream.Failed > 0 {
+			failed += stream.Failed
+			msgs = append(msgs, stream.Name+": "+stream.Error)
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &rejectedError{
+		events: failed,
+		msg:    "openobserve ingestion failed, " + strings.Join(msgs, "; "),
+	}
+}
@@ -0,0 +1,292 @@
+package http
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlp referrer: https://opentelemetry.io/docs/specs/otlp/#otlphttp
+
+const (
+	otlpLogsPath = "/v1/logs"
+
+	otlpEncodingProtobuf = "protobuf"
+	otlpEncodingJSON     = "json"
+
+	// otlpRecordKey holds the protobuf encoded log record of an event
+	otlpRecordKey = "#otlpRecord"
+)
+
+type otlpConfig struct {
+	Encoding           string            `config:"encoding"`
+	ServiceName        string            `config:"service_name"`
+	ResourceAttributes map[string]string `config:"resource_attributes"`
+}
+
+// otlpLogRecord is opentelemetry.proto.logs.v1.LogRecord.
+type otlpLogRecord struct {
+	TimeUnixNano         uint64
+	ObservedTimeUnixNano uint64
+	SeverityNumber       int
+	SeverityText         string
+	Body                 otlpAnyValue
+	Attributes           []otlpKeyValue
+	TraceId              []byte
+	SpanId               []byte
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue holds a string or an int64 value, int64 values are strings in
+// the json mapping.
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+type otlpPartialSuccess struct {
+	RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+	ErrorMessage       string      `json:"errorMessage"`
+}
+
+var otlpSeverities = map[string]int{
+	"TRACE":    1,
+	"DEBUG":    5,
+	"INFO":     9,
+	"NOTICE":   10,
+	"WARN":     13,
+	"WARNING":  13,
+	"ERROR":    17,
+	"ERR":      17,
+	"CRITICAL": 21,
+	"FATAL":    21,
+	"PANIC":    21,
+}
+
+func (c *otlpConfig) Validate() error {
+	switch c.Encoding {
+	case otlpEncodingProtobuf, otlpEncodingJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid otlp.encoding: %s", c.Encoding)
+}
+
+// resource returns the resource attributes shared by all records sent by this beat.
+func (c *otlpConfig) resource(info beat.Info) []otlpKeyValue {
+	attrs := map[string]string{
+		"host.name": info.Hostname,
+		"host.arch": runtime.GOARCH,
+		"os.type":   runtime.GOOS,
+	}
+	if c.ServiceName != "" {
+		attrs["service.name"] = c.ServiceName
+	}
+	for key, val := range c.ResourceAttributes {
+		attrs[key] = val
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ret := make([]otlpKeyValue, 0, len(attrs))
+	for _, key := range keys {
+		if val := attrs[key]; val != "" {
+			ret = append(ret, otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: val}})
+		}
+	}
+	return ret
+}
+
+// makeOTLPLogRecord returns the log record of an event, protobuf encoded
+// under otlpRecordKey or in the json mapping.
+func makeOTLPLogRecord(v beat.Event, msg string, conf *otlpConfig, observed time.Time) eventRaw {
+	r := otlpLogRecord{
+		TimeUnixNano:         uint64(v.Timestamp.UnixNano()),
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		Body:                 otlpAnyValue{StringValue: msg},
+	}
+	r.SeverityNumber, r.SeverityText = getSeverity(msg)
+
+	if path := getEventString(v, "log.file.path"); path != "" {
+		r.Attributes = append(r.Attributes, otlpKeyValue{Key: "log.file.path", Value: otlpAnyValue{StringValue: path}})
+	}
+	if offset, err := v.Fields.GetValue("log.offset"); err == nil {
+		r.Attributes = append(r.Attributes, otlpKeyValue{Key: "log.offset", Value: otlpAnyValue{IntValue: fmt.Sprint(offset)}})
+	}
+
+	// trace_id is 16 bytes and span_id is 8 bytes, hex encoded
+	if id, err := hex.DecodeString(getTraceId(msg)); err == nil && len(id) == 16 {
+		r.TraceId = id
+		if id, err = hex.DecodeString(getSpanId(msg)); err == nil && len(id) == 8 {
+			r.SpanId = id
+		}
+	}
+
+	if conf.Encoding == otlpEncodingJSON {
+		return r.jsonRecord()
+	}
+	return eventRaw{otlpRecordKey: appendOTLPLogRecord(nil, &r)}
+}
+
+// jsonRecord returns the json mapping of the record, the 64 bits integers
+// are strings and the ids are hex encoded.
+func (r *otlpLogRecord) jsonRecord() eventRaw {
+	ret := make(eventRaw)
+	ret["timeUnixNano"] = rawString(strconv.FormatUint(r.TimeUnixNano, 10))
+	ret["observedTimeUnixNano"] = rawString(strconv.FormatUint(r.ObservedTimeUnixNano, 10))
+	if r.SeverityNumber > 0 {
+		ret["severityNumber"] = json.RawMessage(strconv.Itoa(r.SeverityNumber))
+		ret["severityText"] = rawString(r.SeverityText)
+	}
+	ret["body"], _ = json.Marshal(r.Body)
+	if len(r.Attributes) > 0 {
+		ret["attributes"], _ = json.Marshal(r.Attributes)
+	}
+	if len(r.TraceId) > 0 {
+		ret["traceId"] = rawString(hex.EncodeToString(r.TraceId))
+	}
+	if len(r.SpanId) > 0 {
+		ret["spanId"] = rawString(hex.EncodeToString(r.SpanId))
+	}
+	return ret
+}
+
+// getSeverity finds the log level in a json msg ("level":"info") or in the
+// leading words of a text msg ([2006-01-02 15:04:05] INFO xxx).
+func getSeverity(msg string) (int, string) {
+	var level string
+	idx := strings.Index(msg, "\"level\":")
+	if idx >= 0 {
+		level = strings.TrimLeft(msg[idx+8:], " \"")
+		idx = strings.IndexAny(level, "\",}")
+		if idx >= 0 {
+			level = level[:idx]
+		}
+		level = strings.ToUpper(level)
+	} else {
+		head := msg
+		if len(head) > 128 {
+			head = head[:128]
+		}
+		for _, word := range strings.FieldsFunc(head, isSeverityDelimiter) {
+			if _, ok := otlpSeverities[word]; ok {
+				level = word
+				break
+			}
+		}
+	}
+
+	if num, ok := otlpSeverities[level]; ok {
+		return num, level
+	}
+	return 0, ""
+}
+
+func isSeverityDelimiter(r rune) bool {
+	switch r {
+	case ' ', '\t', '[', ']', '|', ':', '=':
+		return true
+	}
+	return false
+}
+
+// checkOTLPResponse reports the records rejected by the collector, they must not be retried.
+func (c *client) checkOTLPResponse(resp []byte) error {
+	var partial otlpPartialSuccess
+	if c.settings.OTLP.Encoding == otlpEncodingJSON {
+		var result struct {
+			PartialSuccess otlpPartialSuccess `json:"partialSuccess"`
+		}
+		if len(resp) > 0 {
+			if err := json.Unmarshal(resp, &result); err != nil {
+				return fmt.Errorf("invalid otlp response: %w", err)
+			}
+		}
+		partial = result.PartialSuccess
+	} else {
+		if err := decodeOTLPPartialSuccess(resp, &partial); err != nil {
+			return fmt.Errorf("invalid otlp response: %w", err)
+		}
+	}
+
+	if rejected, _ := partial.RejectedLogRecords.Int64(); rejected > 0 {
+		return &rejectedError{
+			events: int(rejected),
+			msg:    "otlp partial success: " + partial.ErrorMessage,
+		}
+	}
+	return nil
+}
+
+// decodeOTLPPartialSuccess reads the partial_success field(1) of an ExportLogsServiceResponse.
+func decodeOTLPPartialSuccess(b []byte, partial *otlpPartialSuccess) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num != 1 || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		for len(msg) > 0 {
+			fnum, ftyp, fn := protowire.ConsumeTag(msg)
+			if fn < 0 {
+				return protowire.ParseError(fn)
+			}
+			msg = msg[fn:]
+
+			switch {
+			case fnum == 1 && ftyp == protowire.VarintType:
+				v, vn := protowire.ConsumeVarint(msg)
+				if vn < 0 {
+					return protowire.ParseError(vn)
+				}
+				partial.RejectedLogRecords = json.Number(strconv.FormatInt(int64(v), 10))
+				fn = vn
+			case fnum == 2 && ftyp == protowire.BytesType:
+				v, vn := protowire.ConsumeBytes(msg)
+				if vn < 0 {
+					return protowire.ParseError(vn)
+				}
+				partial.ErrorMessage = string(v)
+				fn = vn
+			default:
+				fn = protowire.ConsumeFieldValue(fnum, ftyp, msg)
+				if fn < 0 {
+					return protowire.ParseError(fn)
+				}
+			}
+			msg = msg[fn:]
+		}
+	}
+	return nil
+}
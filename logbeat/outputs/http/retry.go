@@ -0,0 +1,129 @@
+package http
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common/backoff"
+)
+
+const (
+	backoffEqualJitter        = "equal_jitter"
+	backoffExponential        = "exponential"
+	backoffDecorrelatedJitter = "decorrelated_jitter"
+)
+
+type backoffConfig struct {
+	Init     time.Duration `config:"init"`
+	Max      time.Duration `config:"max"`
+	Strategy string        `config:"strategy"`
+}
+
+var defaultBackoffConfig = backoffConfig{
+	Init:     1 * time.Second,
+	Max:      60 * time.Second,
+	Strategy: backoffEqualJitter,
+}
+
+func (c *backoffConfig) Validate() error {
+	if c.Init <= 0 || c.Max < c.Init {
+		return fmt.Errorf("invalid backoff, init: %v, max: %v", c.Init, c.Max)
+	}
+	switch c.Strategy {
+	case backoffEqualJitter, backoffExponential, backoffDecorrelatedJitter:
+		return nil
+	}
+	return fmt.Errorf("invalid backoff.strategy: %s", c.Strategy)
+}
+
+// clock is the time source of the backoff, replaced in tests.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// retryBackoff waits between the failed attempts, by the strategy:
+//   - equal_jitter: half of the exponential duration plus a random half
+//   - exponential: doubles from init to max
+//   - decorrelated_jitter: random between init and three times the last wait
+type retryBackoff struct {
+	conf  *backoffConfig
+	done  <-chan struct{}
+	clock clock
+	rand  *rand.Rand
+
+	duration time.Duration
+}
+
+func newRetryBackoff(done <-chan struct{}, conf *backoffConfig) backoff.Backoff {
+	return newRetryBackoffClock(done, conf, realClock{}, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+func newRetryBackoffClock(done <-chan struct{}, conf *backoffConfig, c clock, r *rand.Rand) *retryBackoff {
+	b := &retryBackoff{
+		conf:  conf,
+		done:  done,
+		clock: c,
+		rand:  r,
+	}
+	b.Reset()
+	return b
+}
+
+func (b *retryBackoff) Reset() {
+	b.duration = 0
+}
+
+// next returns the duration of the next wait.
+func (b *retryBackoff) next() time.Duration {
+	first, limit := b.conf.Init, b.conf.Max
+
+	switch b.conf.Strategy {
+	case backoffExponential:
+		if b.duration == 0 {
+			b.duration = first
+		} else {
+			b.duration = minDuration(2*b.duration, limit)
+		}
+		return b.duration
+	case backoffDecorrelatedJitter:
+		upper := 3 * b.duration
+		if upper <= first {
+			upper = first + 1
+		}
+		b.duration = minDuration(first+time.Duration(b.rand.Int63n(int64(upper-first))), limit)
+		return b.duration
+	default:
+		// at least the init period on the first wait
+		if b.duration == 0 {
+			b.duration = 2 * first
+		}
+		half := int64(b.duration / 2)
+		wait := time.Duration(half + b.rand.Int63n(half))
+		b.duration = minDuration(2*b.duration, limit)
+		return wait
+	}
+}
+
+// Wait blocks for the next duration, it returns false when done is closed.
+func (b *retryBackoff) Wait() bool {
+	select {
+	case <-b.done:
+		return false
+	case <-b.clock.After(b.next()):
+		return true
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
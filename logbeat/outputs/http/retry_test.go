@@ -0,0 +1,155 @@
+package http
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// fakeClock records the waits and returns at once.
+type fakeClock struct {
+	waits []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func TestRetryBackoff(t *testing.T) {
+	first, limit := 1*time.Second, 10*time.Second
+
+	tests := []struct {
+		strategy string
+		check    func(i int, prev, wait time.Duration) bool
+	}{
+		{
+			strategy: backoffExponential,
+			check: func(i int, prev, wait time.Duration) bool {
+				expected := minDuration(first<<i, limit)
+				return wait == expected
+			},
+		},
+		{
+			strategy: backoffEqualJitter,
+			check: func(i int, prev, wait time.Duration) bool {
+				d := minDuration(2*first<<i, limit)
+				return wait >= d/2 && wait < d
+			},
+		},
+		{
+			strategy: backoffDecorrelatedJitter,
+			check: func(i int, prev, wait time.Duration) bool {
+				upper := 3 * prev
+				if upper <= first {
+					upper = first + 1
+				}
+				return wait >= first && wait < upper && wait <= limit
+			},
+		},
+	}
+
+	for _, test := range tests {
+		conf := backoffConfig{Init: first, Max: limit, Strategy: test.strategy}
+		clock := &fakeClock{}
+		b := newRetryBackoffClock(make(chan struct{}), &conf, clock, rand.New(rand.NewSource(1)))
+
+		for round := 0; round < 2; round++ {
+			clock.waits = nil
+			for i := 0; i < 8; i++ {
+				if !b.Wait() {
+					t.Fatalf("%s: wait interrupted", test.strategy)
+				}
+			}
+
+			var prev time.Duration
+			for i, wait := range clock.waits {
+				if !test.check(i, prev, wait) {
+					t.Errorf("%s: unexpected wait %d: %v after %v", test.strategy, i, wait, prev)
+				}
+				prev = wait
+			}
+			// the next round starts from init again
+			b.Reset()
+		}
+	}
+}
+
+func TestRetryBackoffDone(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+
+	clock := &fakeClock{}
+	b := newRetryBackoffClock(done, &defaultBackoffConfig, clock, rand.New(rand.NewSource(1)))
+	// the closed done channel may win over the ready timer, never wait forever
+	for i := 0; i < 100; i++ {
+		if !b.Wait() {
+			return
+		}
+	}
+	t.Fatal("wait not interrupted by done")
+}
+
+func TestRetryConfig(t *testing.T) {
+	tests := []struct {
+		cfg     map[string]any
+		retries int
+		err     string
+	}{
+		{cfg: map[string]any{}, retries: 3},
+		{cfg: map[string]any{"max_retries": -1}, retries: -1},
+		{cfg: map[string]any{"max_retries": 5, "backoff.strategy": "decorrelated_jitter"}, retries: 5},
+		{cfg: map[string]any{"max_retries": -2}, err: "max_retries"},
+		{cfg: map[string]any{"backoff.strategy": "linear"}, err: "backoff.strategy"},
+		{cfg: map[string]any{"backoff.init": "10s", "backoff.max": "1s"}, err: "invalid backoff"},
+	}
+
+	for _, test := range tests {
+		test.cfg["hosts"] = []string{"127.0.0.1:5080"}
+		out, err := makeHttp(nil, beat.Info{}, nil, config.MustNewConfigFrom(test.cfg))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: expected error %q, got %v", test.cfg, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", test.cfg, err)
+		}
+		if out.Retry != test.retries {
+			t.Errorf("%v: expected %d retries, got %d", test.cfg, test.retries, out.Retry)
+		}
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":           []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":         "openobserve",
+		"attempt_timeout": "50ms",
+		"backoff.init":    "1ms",
+		"backoff.max":     "1ms",
+	})
+
+	start := time.Now()
+	err := sendTestEvents(output, 1, 1)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("attempt not interrupted, took %v", elapsed)
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+// routeConfig sends the events matching When to Destination, a route without
+// condition matches all events.
+type routeConfig struct {
+	Destination string             `config:"destination" validate:"required"`
+	When        *conditions.Config `config:"when"`
+}
+
+type route struct {
+	cond conditions.Condition
+	dest int
+}
+
+// newRoutes returns the routes to the destinations, in the order of the
+// configuration.
+func newRoutes(configs []routeConfig, destinations []*destination) ([]route, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	routes := make([]route, 0, len(configs))
+	for i, conf := range configs {
+		dest := -1
+		for j, d := range destinations {
+			if d.name == conf.Destination {
+				dest = j
+				break
+			}
+		}
+		if dest < 0 {
+			return nil, fmt.Errorf("routes[%d]: unknown destination %s", i, conf.Destination)
+		}
+
+		r := route{dest: dest}
+		if conf.When != nil {
+			var err error
+			if r.cond, err = conditions.NewCondition(conf.When); err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+		}
+		routes = append(routes, r)
+	}
+
+	for _, d := range destinations {
+		if d.cond != nil {
+			return nil, errors.New("the when condition of destination " + d.name + " can't be used with routes")
+		}
+	}
+	return routes, nil
+}
+
+// route groups the events by destination, keeping their order. With routes,
+// an event is sent to the destination of the first route it matches, else to
+// all destinations whose condition it matches. It returns the number of
+// events matching no destination.
+func (r *destinationRouter) route(events []publisher.Event) ([][]publisher.Event, int) {
+	groups := make([][]publisher.Event, len(r.destinations))
+	unrouted := 0
+	for i := range events {
+		event := &events[i].Content
+		matched := false
+		if len(r.routes) > 0 {
+			for _, rt := range r.routes {
+				if rt.cond == nil || rt.cond.Check(event) {
+					groups[rt.dest] = append(groups[rt.dest], events[i])
+					matched = true
+					break
+				}
+			}
+		} else {
+			for j, d := range r.destinations {
+				if d.match(event) {
+					groups[j] = append(groups[j], events[i])
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			unrouted++
+		}
+	}
+	return groups, unrouted
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+type saResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// checkSaResponse returns the error of a Sensors Data response. The accepted
+// requests have an empty body, or a json body with code 0. Sensors Data
+// rejects the whole request when a record is invalid, without telling which
+// one, so all its events are dropped.
+func checkSaResponse(resp []byte) error {
+	resp = bytes.TrimSpace(resp)
+	if len(resp) == 0 || resp[0] != '{' {
+		return nil
+	}
+
+	var result saResponse
+	if err := json.Unmarshal(resp, &result); err != nil || result.Code == 0 {
+		return nil
+	}
+	return &rejectedError{msg: fmt.Sprintf("sa publish fail, code: %d, message: %s", result.Code, result.Message)}
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const maskedValue = "xxxxx"
+
+// secretQueryKeys are the query parameters masked in logs.
+var secretQueryKeys = []string{"token", "key", "secret", "password", "sign", "appid", "app_id"}
+
+// secretFiles are the file references of the output credentials, they are
+// read again when the files change, e.g. mounted kubernetes secrets.
+type secretFiles struct {
+	password *fileValue
+	appId    *fileValue
+	headers  map[string]*fileValue
+	params   map[string]*fileValue
+}
+
+func newSecretFiles(s *clientSettings) *secretFiles {
+	ret := &secretFiles{}
+	if s.PasswordFile != "" {
+		ret.password = newFileValue(s.PasswordFile)
+	}
+	if s.AppIdFile != "" {
+		ret.appId = newFileValue(s.AppIdFile)
+	}
+	if len(s.HeaderFiles) > 0 {
+		ret.headers = make(map[string]*fileValue, len(s.HeaderFiles))
+		for name, path := range s.HeaderFiles {
+			ret.headers[name] = newFileValue(path)
+		}
+	}
+	if len(s.ParamFiles) > 0 {
+		ret.params = make(map[string]*fileValue, len(s.ParamFiles))
+		for name, path := range s.ParamFiles {
+			ret.params[name] = newFileValue(path)
+		}
+	}
+	return ret
+}
+
+// addParams adds the query parameters read from files to strUrl.
+func (f *secretFiles) addParams(strUrl string) (string, error) {
+	if len(f.params) == 0 {
+		return strUrl, nil
+	}
+
+	params := make(map[string]string, len(f.params))
+	for name, file := range f.params {
+		val, err := file.Get()
+		if err != nil {
+			return "", err
+		}
+		params[name] = val
+	}
+	return addToURL(strUrl, "", params), nil
+}
+
+func (c *httpConfig) validateSecrets() error {
+	if c.Password != "" && c.PasswordFile != "" {
+		return errors.New("password and password_file can't be both set")
+	}
+	if c.AppId != "" && c.AppIdFile != "" {
+		return errors.New("app_id and app_id_file can't be both set")
+	}
+	return nil
+}
+
+// maskURL hides the secrets in the query of strUrl, so it can be logged.
+func maskURL(strUrl string) string {
+	return maskURLParams(strUrl, isSecretKey)
+}
+
+// maskURL also hides the query parameters read from the secret files.
+func (s *secretFiles) maskURL(strUrl string) string {
+	return maskURLParams(strUrl, func(key string) bool {
+		return isSecretKey(key) || s.params[key] != nil
+	})
+}
+
+// isSecretHeader reports whether the header is read from a secret file.
+func (s *secretFiles) isSecretHeader(name string) bool {
+	for key := range s.headers {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskURLParams(strUrl string, isSecret func(key string) bool) string {
+	u, err := url.Parse(strUrl)
+	if err != nil {
+		return strUrl
+	}
+	if u.User != nil {
+		u.User = url.User(maskedValue)
+	}
+
+	values := u.Query()
+	masked := false
+	for key := range values {
+		if isSecret(key) {
+			values.Set(key, maskedValue)
+			masked = true
+		}
+	}
+	if masked {
+		u.RawQuery = values.Encode()
+	}
+	return u.String()
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretQueryKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFileValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, path, " first\n")
+	f := newFileValue(path)
+
+	get := func(expected string) {
+		t.Helper()
+		if got, err := f.Get(); err != nil || got != expected {
+			t.Fatalf("expected %q, got %q: %v", expected, got, err)
+		}
+	}
+	get("first")
+
+	// the file is checked at most once per fileCheckInterval
+	writeFile(t, path, "second")
+	get("first")
+	expire(f)
+	get("second")
+
+	// the last value is kept while the file is replaced
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expire(f)
+	get("second")
+
+	if _, err := newFileValue(path).Get(); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		mu.Lock()
+		received = append(received, password+"|"+r.Header.Get("X-Token")+"|"+r.URL.Query().Get("token"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	files := map[string]string{"password": "p1", "header": "h1", "param": "q1"}
+	for name, value := range files {
+		writeFile(t, filepath.Join(dir, name), value)
+	}
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":         []string{strings.TrimPrefix(srv.URL, "http://")},
+		"channel":       "openobserve",
+		"username":      "user",
+		"password_file": filepath.Join(dir, "password"),
+		"header_files":  map[string]any{"X-Token": filepath.Join(dir, "header")},
+		"param_files":   map[string]any{"token": filepath.Join(dir, "param")},
+	})
+	if err := sendTestEvents(output, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rotated secrets are read again
+	for name := range files {
+		writeFile(t, filepath.Join(dir, name), name+"-rotated")
+	}
+	secrets := output.(*client).conn.secrets
+	expire(secrets.password, secrets.headers["X-Token"], secrets.params["token"])
+	if err := sendTestEvents(output, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	got := strings.Join(received, ",")
+	mu.Unlock()
+	if got != "p1|h1|q1,password-rotated|header-rotated|param-rotated" {
+		t.Fatalf("unexpected secrets %s", got)
+	}
+
+	// the secrets are masked in the logged urls
+	if masked := secrets.maskURL("http://u:p@host/path?token=q1&app=logs&my_key=k"); masked != "http://xxxxx@host/path?app=logs&my_key=xxxxx&token=xxxxx" {
+		t.Errorf("unexpected masked url %s", masked)
+	}
+	if masked := maskURL("http://host/sa?project=default&token=abc"); masked != "http://host/sa?project=default&token=xxxxx" {
+		t.Errorf("unexpected masked url %s", masked)
+	}
+}
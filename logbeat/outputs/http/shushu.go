@@ -0,0 +1,45 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ThinkingData answers every request with status 200 and a code:
+// https://docs.thinkingdata.cn/ta-manual/latest/installation/installation_menu/restful_api.html
+const (
+	shushuInvalidData  = -1
+	shushuInvalidAppId = -2
+)
+
+var shushuRejections = map[int]string{
+	shushuInvalidData:  "invalid data",
+	shushuInvalidAppId: "invalid app id",
+}
+
+type shushuResponse struct {
+	Code *int   `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// checkShushuResponse returns the error of a ThinkingData response. The
+// requests with invalid data or app id are rejected, the response doesn't
+// tell which events of a batch are invalid. The other codes are retried. A
+// response without code, e.g. the page of a proxy, is accepted: retrying it
+// could send the events forever.
+func (c *client) checkShushuResponse(resp []byte) error {
+	var result shushuResponse
+	if err := json.Unmarshal(resp, &result); err != nil || result.Code == nil {
+		c.conn.log.Warnf("invalid shushu response, the events are acked: %.256s", resp)
+		return nil
+	}
+
+	switch code := *result.Code; code {
+	case 0:
+		return nil
+	case shushuInvalidData, shushuInvalidAppId:
+		return &rejectedError{msg: fmt.Sprintf("shushu publish fail (%s), code: %d, msg: %s", shushuRejections[code], code, result.Msg)}
+	default:
+		return fmt.Errorf("shushu publish fail, code: %d, msg: %s", code, result.Msg)
+	}
+}
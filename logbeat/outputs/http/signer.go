@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	signerHMAC     = "hmac"
+	signerAWSSigV4 = "aws_sigv4"
+
+	hmacEncodingHex    = "hex"
+	hmacEncodingBase64 = "base64"
+)
+
+// requestSigner signs a request after its body is encoded and its headers are set.
+type requestSigner interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+type signerConfig struct {
+	Type string           `config:"type"`
+	HMAC hmacSignerConfig `config:"hmac"`
+	AWS  awsSignerConfig  `config:"aws"`
+}
+
+type hmacSignerConfig struct {
+	Key             string `config:"key"`
+	KeyId           string `config:"key_id"`
+	KeyIdHeader     string `config:"key_id_header"`
+	Header          string `config:"header"`
+	TimestampHeader string `config:"timestamp_header"`
+	Prefix          string `config:"prefix"`
+	Encoding        string `config:"encoding"`
+}
+
+type awsSignerConfig struct {
+	Region          string `config:"region"`
+	Service         string `config:"service"`
+	AccessKeyId     string `config:"access_key_id"`
+	SecretAccessKey string `config:"secret_access_key"`
+	SessionToken    string `config:"session_token"`
+}
+
+var defaultSignerConfig = signerConfig{
+	HMAC: hmacSignerConfig{
+		KeyIdHeader:     "X-Key-Id",
+		Header:          "X-Signature",
+		TimestampHeader: "X-Timestamp",
+		Encoding:        hmacEncodingHex,
+	},
+	AWS: awsSignerConfig{
+		Service: "execute-api",
+	},
+}
+
+func (c *signerConfig) Validate() error {
+	switch c.Type {
+	case "":
+	case signerHMAC:
+		if c.HMAC.Key == "" {
+			return errors.New("signer.hmac.key is required")
+		}
+		if c.HMAC.Encoding != hmacEncodingHex && c.HMAC.Encoding != hmacEncodingBase64 {
+			return fmt.Errorf("invalid signer.hmac.encoding: %s", c.HMAC.Encoding)
+		}
+	case signerAWSSigV4:
+		if c.AWS.Region == "" {
+			return errors.New("signer.aws.region is required")
+		}
+	default:
+		return fmt.Errorf("invalid signer.type: %s", c.Type)
+	}
+	return nil
+}
+
+func newRequestSigner(c *signerConfig) (requestSigner, error) {
+	switch c.Type {
+	case signerHMAC:
+		return &hmacSigner{conf: c.HMAC, now: time.Now}, nil
+	case signerAWSSigV4:
+		creds := aws.Credentials{
+			AccessKeyID:     c.AWS.AccessKeyId,
+			SecretAccessKey: c.AWS.SecretAccessKey,
+			SessionToken:    c.AWS.SessionToken,
+		}
+		if creds.AccessKeyID == "" {
+			creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, errors.New("aws credentials are not configured")
+		}
+		return &awsSigner{
+			signer:  v4.NewSigner(),
+			creds:   creds,
+			region:  c.AWS.Region,
+			service: c.AWS.Service,
+			now:     time.Now,
+		}, nil
+	}
+	return nil, nil
+}
+
+// hmacSigner signs hmac_sha256(key, timestamp + "\n" + body), the unix
+// timestamp and the signature are sent in headers.
+type hmacSigner struct {
+	conf hmacSignerConfig
+	now  func() time.Time
+}
+
+func (s *hmacSigner) Sign(req *http.Request, body []byte) error {
+	ts := strconv.FormatInt(s.now().Unix(), 10)
+
+	h := hmac.New(sha256.New, []byte(s.conf.Key))
+	h.Write([]byte(ts))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+
+	var sign string
+	if s.conf.Encoding == hmacEncodingBase64 {
+		sign = base64.StdEncoding.EncodeToString(h.Sum(nil))
+	} else {
+		sign = hex.EncodeToString(h.Sum(nil))
+	}
+
+	req.Header.Set(s.conf.TimestampHeader, ts)
+	req.Header.Set(s.conf.Header, s.conf.Prefix+sign)
+	if s.conf.KeyId != "" {
+		req.Header.Set(s.conf.KeyIdHeader, s.conf.KeyId)
+	}
+	return nil
+}
+
+// awsSigner signs the request with aws signature version 4, for api gateway
+// and opensearch service endpoints.
+type awsSigner struct {
+	signer  *v4.Signer
+	creds   aws.Credentials
+	region  string
+	service string
+	now     func() time.Time
+}
+
+func (s *awsSigner) Sign(req *http.Request, body []byte) error {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	return s.signer.SignHTTP(context.Background(), s.creds, req, payloadHash, s.service, s.region, s.now())
+}
+
+// requestBody returns the encoded body of req without consuming it.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestHMACSigner(t *testing.T) {
+	const key = "test-key"
+
+	for _, level := range []int{0, 5} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			h := hmac.New(sha256.New, []byte(key))
+			h.Write([]byte(r.Header.Get("X-Timestamp") + "\n"))
+			h.Write(body)
+			expected := "sha256=" + hex.EncodeToString(h.Sum(nil))
+			if r.Header.Get("X-Signature") != expected || r.Header.Get("X-Key-Id") != "k1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("{}"))
+		}))
+
+		signer := defaultSignerConfig
+		signer.Type = signerHMAC
+		signer.HMAC.Key = key
+		signer.HMAC.KeyId = "k1"
+		signer.HMAC.Prefix = "sha256="
+
+		status := testSignedRequest(t, srv.URL, level, signer)
+		srv.Close()
+		if status != http.StatusOK {
+			t.Errorf("compression %d: hmac signature rejected, status: %d", level, status)
+		}
+	}
+}
+
+func TestAWSSigner(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signingTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		payloadHash := hex.EncodeToString(sum[:])
+		if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// sign the received request again, the signature must be the same
+		req := r.Clone(context.Background())
+		req.URL.Scheme = "http"
+		req.URL.Host = r.Host
+		req.Header.Del("Authorization")
+		req.Header.Del("X-Amz-Date")
+		req.Header.Del("Accept-Encoding")
+		req.Header.Del("User-Agent")
+		err = v4.NewSigner().SignHTTP(context.Background(), creds, req, payloadHash, "es", "us-east-1", signingTime)
+		if err != nil || req.Header.Get("Authorization") != r.Header.Get("Authorization") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	signer := defaultSignerConfig
+	signer.Type = signerAWSSigV4
+	signer.AWS.Region = "us-east-1"
+	signer.AWS.Service = "es"
+	signer.AWS.AccessKeyId = creds.AccessKeyID
+	signer.AWS.SecretAccessKey = creds.SecretAccessKey
+
+	if status := testSignedRequest(t, srv.URL, 0, signer); status != http.StatusOK {
+		t.Errorf("aws sigv4 signature rejected, status: %d", status)
+	}
+}
+
+func TestAWSSignerAuth(t *testing.T) {
+	for _, auth := range []map[string]any{
+		{"username": "user", "password": "secret"},
+		{"password_file": "/run/secrets/password"},
+		{"bearer_token": "token"},
+		{"bearer_token_file": "/run/secrets/token"},
+		{"oauth2.token_url": "https://auth.example.com/token", "oauth2.client_id": "id", "oauth2.client_secret": "secret"},
+	} {
+		auth["signer.type"] = signerAWSSigV4
+		auth["signer.aws.region"] = "us-east-1"
+		auth["signer.aws.service"] = "es"
+		conf := defaultConfig
+		if err := config.MustNewConfigFrom(auth).Unpack(&conf); err == nil || !strings.Contains(err.Error(), "aws_sigv4") {
+			t.Errorf("expected an error for aws_sigv4 with %v, got %v", auth, err)
+		}
+	}
+}
+
+func testSignedRequest(t *testing.T, url string, level int, signer signerConfig) int {
+	conn, err := NewConnection(&clientSettings{
+		URL:              url,
+		CompressionLevel: level,
+		Headers:          map[string]string{"X-Test": "1"},
+		Signer:           signer,
+		Observer:         outputs.NewNilObserver(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create connection: %v", err)
+	}
+	defer conn.Close()
+
+	status, _, _ := conn.RequestURL(http.MethodPost, url+"/_doc?refresh=true", []eventRaw{{"log": rawString("test")}})
+	return status
+}
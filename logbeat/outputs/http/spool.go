@@ -0,0 +1,289 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const spoolFileExt = ".spool"
+
+type spoolConfig struct {
+	// Path is the spool directory, the spool is disabled when not set.
+	Path string `config:"path"`
+	// MaxAttempts is the number of failed attempts of a request before its
+	// body is spooled.
+	MaxAttempts int              `config:"max_attempts" validate:"min=1"`
+	MaxSize     cfgtype.ByteSize `config:"max_size" validate:"min=1"`
+	// ReplayRequests is the number of spooled requests replayed after every
+	// successful batch.
+	ReplayRequests int `config:"replay_requests" validate:"min=1"`
+}
+
+var defaultSpoolConfig = spoolConfig{
+	MaxAttempts:    3,
+	MaxSize:        1 << 30,
+	ReplayRequests: 10,
+}
+
+// spoolHeader is the first line of a spool file, followed by the encoded
+// request body.
+type spoolHeader struct {
+	Events          int    `json:"events"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	IdempotencyKey  string `json:"idempotency_key,omitempty"`
+}
+
+type spoolEntry struct {
+	seq    uint64
+	events int
+	size   int64
+}
+
+func (e spoolEntry) name() string {
+	return fmt.Sprintf("%020d-%d%s", e.seq, e.events, spoolFileExt)
+}
+
+// spool persists the request bodies which can't be sent during a backend
+// outage, in files named by sequence. The files are replayed oldest first,
+// and the oldest are evicted when the spool exceeds max_size.
+type spool struct {
+	conf  *spoolConfig
+	log   *logp.Logger
+	drops *dropStats
+	// idempotencyHeader is kept with the body, so the replays can be
+	// de-duplicated as well
+	idempotencyHeader string
+
+	mu      sync.Mutex
+	entries []spoolEntry
+	size    int64
+	next    uint64
+
+	replaying atomic.Bool
+
+	entriesGauge *monitoring.Int
+	bytesGauge   *monitoring.Int
+	spooled      *monitoring.Int
+	replayed     *monitoring.Int
+	evicted      *monitoring.Int
+}
+
+// newSpool loads the files left by the previous run, it returns nil when the
+// spool is disabled.
+func newSpool(conf *spoolConfig, idempotencyHeader string, reg *monitoring.Registry, drops *dropStats, log *logp.Logger) (*spool, error) {
+	if conf.Path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(conf.Path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	spoolReg := subRegistry(reg, "spool")
+	s := &spool{
+		conf:              conf,
+		log:               log,
+		drops:             drops,
+		idempotencyHeader: idempotencyHeader,
+		entriesGauge:      monitoring.NewInt(spoolReg, "entries"),
+		bytesGauge:        monitoring.NewInt(spoolReg, "bytes"),
+		spooled:           monitoring.NewInt(spoolReg, "events.spooled"),
+		replayed:          monitoring.NewInt(spoolReg, "events.replayed"),
+		evicted:           monitoring.NewInt(spoolReg, "events.evicted"),
+	}
+
+	files, err := os.ReadDir(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		entry, ok := parseSpoolName(f.Name())
+		if !ok {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entry.size = info.Size()
+		s.entries = append(s.entries, entry)
+		s.size += entry.size
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+	if n := len(s.entries); n > 0 {
+		s.next = s.entries[n-1].seq + 1
+		log.Infof("spool has %d requests to replay (%d bytes)", n, s.size)
+	}
+	s.updateGauges()
+	return s, nil
+}
+
+func parseSpoolName(name string) (spoolEntry, bool) {
+	base, ok := strings.CutSuffix(name, spoolFileExt)
+	if !ok {
+		return spoolEntry{}, false
+	}
+	seqStr, eventsStr, ok := strings.Cut(base, "-")
+	if !ok {
+		return spoolEntry{}, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return spoolEntry{}, false
+	}
+	events, err := strconv.Atoi(eventsStr)
+	if err != nil {
+		return spoolEntry{}, false
+	}
+	return spoolEntry{seq: seq, events: events}, true
+}
+
+func (s *spool) updateGauges() {
+	s.entriesGauge.Set(int64(len(s.entries)))
+	s.bytesGauge.Set(s.size)
+}
+
+// add spools the body of req, which holds events.
+func (s *spool) add(req *http.Request, events int) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	h := spoolHeader{
+		Events:          events,
+		ContentType:     req.Header.Get("Content-Type"),
+		ContentEncoding: req.Header.Get("Content-Encoding"),
+	}
+	if s.idempotencyHeader != "" {
+		h.IdempotencyKey = req.Header.Get(s.idempotencyHeader)
+	}
+	header, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(header) + 1 + len(body))
+	if size > int64(s.conf.MaxSize) {
+		return fmt.Errorf("request of %d bytes exceeds the spool size", size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.size+size > int64(s.conf.MaxSize) && len(s.entries) > 0 {
+		s.evictOldest()
+	}
+
+	entry := spoolEntry{seq: s.next, events: events, size: size}
+	path := filepath.Join(s.conf.Path, entry.name())
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(append(header, '\n'), body...), 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.next++
+	s.entries = append(s.entries, entry)
+	s.size += size
+	s.spooled.Add(int64(events))
+	s.updateGauges()
+	return nil
+}
+
+func (s *spool) evictOldest() {
+	entry := s.entries[0]
+	s.entries = s.entries[1:]
+	s.size -= entry.size
+	if err := os.Remove(filepath.Join(s.conf.Path, entry.name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warnf("Failed to remove spool file: %v", err)
+	}
+	s.evicted.Add(int64(entry.events))
+	s.drops.count(dropSpoolEvicted, entry.events)
+	s.drops.logf("spool is full, dropping %d events of the oldest request", entry.events)
+}
+
+// oldest reads the oldest spooled request.
+func (s *spool) oldest() (spoolEntry, []byte, http.Header, bool) {
+	s.mu.Lock()
+	if len(s.entries) == 0 {
+		s.mu.Unlock()
+		return spoolEntry{}, nil, nil, false
+	}
+	entry := s.entries[0]
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(s.conf.Path, entry.name()))
+	if err != nil {
+		s.log.Errorf("Failed to read spool file, dropping it: %v", err)
+		s.remove(entry)
+		return s.oldest()
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	line, err := r.ReadBytes('\n')
+	var header spoolHeader
+	if err == nil {
+		err = json.Unmarshal(line, &header)
+	}
+	if err != nil {
+		s.log.Errorf("Invalid spool file %s, dropping it: %v", entry.name(), err)
+		s.remove(entry)
+		return s.oldest()
+	}
+
+	httpHeader := http.Header{}
+	if header.ContentType != "" {
+		httpHeader.Set("Content-Type", header.ContentType)
+	}
+	if header.ContentEncoding != "" {
+		httpHeader.Set("Content-Encoding", header.ContentEncoding)
+	}
+	if header.IdempotencyKey != "" && s.idempotencyHeader != "" {
+		httpHeader.Set(s.idempotencyHeader, header.IdempotencyKey)
+	}
+	return entry, data[len(line):], httpHeader, true
+}
+
+// remove deletes a replayed entry, it may have been evicted meanwhile.
+func (s *spool) remove(entry spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if e.seq != entry.seq {
+			continue
+		}
+		s.entries = append(s.entries[:i], s.entries[i+1:]...)
+		s.size -= e.size
+		if err := os.Remove(filepath.Join(s.conf.Path, e.name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.log.Warnf("Failed to remove spool file: %v", err)
+		}
+		break
+	}
+	s.updateGauges()
+}
+
+// retryableStatus reports whether a failed request may succeed later: no
+// response, a request timeout, too many requests or a server error.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
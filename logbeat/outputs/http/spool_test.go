@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"logbeat/outputs/http/fakeserver"
+)
+
+func spoolRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "key-"+body)
+	return req
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	conf := spoolConfig{Path: dir, MaxAttempts: 1, MaxSize: 400, ReplayRequests: 1}
+	reg := monitoring.NewRegistry()
+	log := logp.NewLogger(loggerName)
+	drops := newDropStats(reg, log)
+
+	s, err := newSpool(&conf, "Idempotency-Key", reg, drops, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func(i int) string { return fmt.Sprintf(`[{"log":"request %d","padding":"0123456789"}]`, i) }
+	for i := 1; i <= 5; i++ {
+		if err = s.add(spoolRequest(t, body(i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every file is about 170 bytes, the oldest ones are evicted
+	if len(s.entries) != 2 || s.size > int64(conf.MaxSize) {
+		t.Fatalf("expected 2 entries within max_size, got %d of %d bytes", len(s.entries), s.size)
+	}
+	if evicted := s.evicted.Get(); evicted != 1+2+3 {
+		t.Fatalf("expected 6 evicted events, got %d", evicted)
+	}
+	if dropped := drops.counters[dropSpoolEvicted].Get(); dropped != 6 {
+		t.Fatalf("expected 6 dropped events, got %d", dropped)
+	}
+	if err = s.add(spoolRequest(t, string(make([]byte, 500))), 1); err == nil {
+		t.Fatal("expected an error for a request larger than max_size")
+	}
+
+	// the files are replayed oldest first after a restart
+	s, err = newSpool(&conf, "Idempotency-Key", monitoring.NewRegistry(), drops, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{4, 5} {
+		entry, data, header, ok := s.oldest()
+		if !ok {
+			t.Fatalf("request %d not found", i)
+		}
+		if entry.events != i || string(data) != body(i) {
+			t.Fatalf("expected request %d, got %d events: %s", i, entry.events, data)
+		}
+		if header.Get("Content-Type") != "application/json" || header.Get("Idempotency-Key") != "key-"+body(i) {
+			t.Fatalf("unexpected header %v", header)
+		}
+		s.remove(entry)
+	}
+	if _, _, _, ok := s.oldest(); ok {
+		t.Fatal("expected an empty spool")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected the spool files removed, got %d", len(files))
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	srv := fakeserver.New(fakeserver.OpenObserve)
+	defer srv.Close()
+
+	output := newTestOutput(t, map[string]any{
+		"hosts":                 []string{srv.Host()},
+		"path":                  fakeserver.OpenObservePath,
+		"channel":               "openobserve",
+		"batch_mode":            true,
+		"backoff.init":          "1ms",
+		"backoff.max":           "1ms",
+		"spool.path":            t.TempDir(),
+		"spool.max_attempts":    1,
+		"spool.replay_requests": 2,
+	})
+	spool := output.(*client).settings.Spool
+
+	publish := func(ids ...int) {
+		t.Helper()
+		batch := outest.NewBatch(createEvent(ids[0]), createEvent(ids[1]))
+		if err := output.Publish(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch.Signals) != 1 || batch.Signals[0].Tag != outest.BatchACK {
+			t.Fatalf("expected the batch to be acked, got %v", batch.Signals)
+		}
+	}
+
+	// the failed requests are spooled and their batches acked
+	srv.Fail(http.StatusServiceUnavailable, 3)
+	publish(1, 2)
+	publish(3, 4)
+	publish(5, 6)
+	if n := spool.entriesGauge.Get(); n != 3 {
+		t.Fatalf("expected 3 spooled requests, got %d", n)
+	}
+
+	// every successful batch replays at most replay_requests requests
+	publish(7, 8)
+	if n := spool.entriesGauge.Get(); n != 1 {
+		t.Fatalf("expected 1 spooled request, got %d", n)
+	}
+	publish(9, 10)
+
+	var got []string
+	for _, e := range srv.Events() {
+		var record struct {
+			Log string `json:"log"`
+		}
+		if err := json.Unmarshal(e, &record); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record.Log)
+	}
+	expected := []string{"test 7", "test 8", "test 1", "test 2", "test 3", "test 4", "test 9", "test 10", "test 5", "test 6"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v, got %v", expected, got)
+	}
+	if n := spool.replayed.Get(); n != 6 {
+		t.Fatalf("expected 6 replayed events, got %d", n)
+	}
+
+	// the replayed requests are counted with the sent requests
+	reg := monitoring.Default.GetRegistry(metricsRegistryName)
+	events := reg.Get(hostRegistryName(channelOpenObserve, output.(*client).url) + ".events").(*monitoring.Int)
+	if n := events.Get(); n != 16 {
+		t.Fatalf("expected 16 sent events, got %d", n)
+	}
+}
+
+func TestSpoolReplayStopped(t *testing.T) {
+	srv := fakeserver.New(fakeserver.OpenObserve)
+	defer srv.Close()
+
+	out, err := makeHttp(nil, beat.Info{}, outputs.NewNilObserver(), config.MustNewConfigFrom(map[string]any{
+		"hosts":              []string{srv.Host()},
+		"path":               fakeserver.OpenObservePath,
+		"channel":            "openobserve",
+		"backoff.init":       "1ms",
+		"backoff.max":        "1ms",
+		"spool.path":         t.TempDir(),
+		"spool.max_attempts": 1,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := out.Clients[0].(*client)
+	if err = cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Fail(http.StatusServiceUnavailable, 1)
+	if err = cli.Publish(context.Background(), outest.NewBatch(createEvent(1))); err != nil {
+		t.Fatal(err)
+	}
+	requests := srv.Requests()
+
+	// a closed client doesn't replay
+	_ = cli.Close()
+	cli.replaySpool(context.Background())
+	if srv.Requests() != requests || cli.settings.Spool.entriesGauge.Get() != 1 {
+		t.Fatal("the spool was replayed by a closed client")
+	}
+}
+
+func TestSpoolConfig(t *testing.T) {
+	conf := defaultConfig
+	err := config.MustNewConfigFrom(map[string]any{
+		"channel":    "webhook",
+		"spool.path": t.TempDir(),
+	}).Unpack(&conf)
+	if err == nil || !strings.Contains(err.Error(), "spool") {
+		t.Fatalf("expected the spool to be rejected with channel webhook, got %v", err)
+	}
+}
@@ -9,3 +9,18 @@ const (
 )
 
 type eventRaw map[string]json.RawMessage
+
+// rawString returns s as a json string value.
+func rawString(s string) json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// size returns the approximate length of the json encoded event.
+func (e eventRaw) size() int {
+	n := 2
+	for key, val := range e {
+		n += len(key) + len(val) + 4
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"golang.org/x/time/rate"
+)
+
+// slack referrer: https://api.slack.com/messaging/webhooks
+// feishu referrer: https://open.feishu.cn/document/client-docs/bot-v3/add-custom-bot
+// dingtalk referrer: https://open.dingtalk.com/document/orgapp/custom-robot-access
+
+const (
+	webhookSlack    = "slack"
+	webhookFeishu   = "feishu"
+	webhookDingTalk = "dingtalk"
+
+	webhookTick = 1 * time.Second
+)
+
+// webhookRateLimits are the messages per minute accepted by a robot.
+var webhookRateLimits = map[string]int{
+	webhookSlack:    60,
+	webhookFeishu:   100,
+	webhookDingTalk: 20,
+}
+
+type webhookConfig struct {
+	Format    string             `config:"format"`
+	Secret    string             `config:"secret"`
+	Title     string             `config:"title"`
+	When      *conditions.Config `config:"when"`
+	Window    time.Duration      `config:"window"`
+	MaxLines  int                `config:"max_lines" validate:"min=1"`
+	RateLimit int                `config:"rate_limit" validate:"min=0"`
+}
+
+type webhookResponse struct {
+	Code       *int   `json:"code"`
+	StatusCode *int   `json:"StatusCode"`
+	ErrCode    *int   `json:"errcode"`
+	Msg        string `json:"msg"`
+	ErrMsg     string `json:"errmsg"`
+}
+
+// webhookNotifier aggregates the matched lines, and sends them in one message
+// per window, within the rate limit of the robot.
+type webhookNotifier struct {
+	conf    *webhookConfig
+	cond    conditions.Condition
+	limiter *rate.Limiter
+	notify  chan struct{}
+
+	mu       sync.Mutex
+	lines    []string
+	skipped  int
+	lastSent time.Time
+}
+
+func (c *webhookConfig) Validate() error {
+	if _, ok := webhookRateLimits[c.Format]; !ok {
+		return fmt.Errorf("invalid webhook.format: %s", c.Format)
+	}
+	return nil
+}
+
+func newWebhookNotifier(conf *webhookConfig) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		conf:   conf,
+		notify: make(chan struct{}, 1),
+	}
+
+	if conf.When != nil {
+		cond, err := conditions.NewCondition(conf.When)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create webhook condition: %w", err)
+		}
+		n.cond = cond
+	}
+
+	perMinute := webhookRateLimits[conf.Format]
+	if conf.RateLimit > 0 && conf.RateLimit < perMinute {
+		perMinute = conf.RateLimit
+	}
+	n.limiter = rate.NewLimiter(rate.Limit(float64(perMinute)/60), 1)
+	return n, nil
+}
+
+// add keeps at most MaxLines lines, the others are only counted.
+func (n *webhookNotifier) add(line string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.lines) < n.conf.MaxLines {
+		n.lines = append(n.lines, line)
+	} else {
+		n.skipped++
+	}
+}
+
+func (n *webhookNotifier) due(now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.lines) > 0 && now.Sub(n.lastSent) >= n.conf.Window
+}
+
+// take returns the text of the next message and resets the pending lines.
+func (n *webhookNotifier) take(now time.Time) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var sb strings.Builder
+	if n.conf.Title != "" {
+		sb.WriteString(n.conf.Title)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(strings.Join(n.lines, "\n"))
+	if n.skipped > 0 {
+		sb.WriteString(fmt.Sprintf("\n... and %d more lines", n.skipped))
+	}
+
+	n.lines = nil
+	n.skipped = 0
+	n.lastSent = now
+	return sb.String()
+}
+
+// body builds the robot message, feishu carries the signature in the body.
+func (n *webhookNotifier) body(text string, now time.Time) map[string]any {
+	switch n.conf.Format {
+	case webhookFeishu:
+		ret := map[string]any{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if n.conf.Secret != "" {
+			ts := now.Unix()
+			ret["timestamp"] = strconv.FormatInt(ts, 10)
+			ret["sign"] = feishuSign(n.conf.Secret, ts)
+		}
+		return ret
+	case webhookDingTalk:
+		return map[string]any{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}
+	default:
+		return map[string]any{"text": text}
+	}
+}
+
+// url returns the webhook url, dingtalk carries the signature in the query.
+func (n *webhookNotifier) url(strUrl string, now time.Time) string {
+	if n.conf.Format != webhookDingTalk || n.conf.Secret == "" {
+		return strUrl
+	}
+	ts := now.UnixMilli()
+	return addToURL(strUrl, "", map[string]string{
+		"timestamp": strconv.FormatInt(ts, 10),
+		"sign":      dingTalkSign(n.conf.Secret, ts),
+	})
+}
+
+// feishuSign: base64(hmac_sha256(key=timestamp+"\n"+secret, msg=""))
+func feishuSign(secret string, ts int64) string {
+	h := hmac.New(sha256.New, []byte(strconv.FormatInt(ts, 10)+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// dingTalkSign: base64(hmac_sha256(key=secret, msg=timestamp+"\n"+secret))
+func dingTalkSign(secret string, ts int64) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(ts, 10) + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// publishWebhook queues the lines matching the condition, they are sent by
+// runWebhook. Alerts are best effort, so all events are acked.
+func (c *client) publishWebhook(data []publisher.Event, evts []eventRaw) ([]publisher.Event, error) {
+	n := c.webhook
+	matched := 0
+	for i, e := range evts {
+		if n.cond != nil && !n.cond.Check(&data[i].Content) {
+			continue
+		}
+		n.add(string(e[originMsgKey]))
+		matched++
+	}
+
+	c.conn.log.Debugf("[webhook] total: %d, matched: %d", len(data), matched)
+	c.observer.Acked(len(data))
+	if matched > 0 {
+		select {
+		case n.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil, nil
+}
+
+// runWebhook sends the aggregated lines, it is the only sender so the
+// connection isn't shared.
+func (c *client) runWebhook() {
+	n := c.webhook
+	ticker := time.NewTicker(webhookTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		case <-n.notify:
+		}
+
+		now := time.Now()
+		if !n.due(now) || !n.limiter.Allow() {
+			continue
+		}
+
+		text := n.take(now)
+		if err := c.sendWebhook(text, now); err != nil {
+			c.conn.log.Errorf("webhook send fail: %v", err)
+		}
+	}
+}
+
+func (c *client) sendWebhook(text string, now time.Time) error {
+	n := c.webhook
+	_, resp, err := c.conn.RequestURL(http.MethodPost, n.url(c.url, now), n.body(text, now))
+	if err != nil {
+		return err
+	}
+
+	if n.conf.Format == webhookSlack {
+		return nil
+	}
+
+	var result webhookResponse
+	if err = json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("invalid webhook response: %w", err)
+	}
+	for _, code := range []*int{result.Code, result.StatusCode, result.ErrCode} {
+		if code != nil && *code != 0 {
+			return fmt.Errorf("webhook error, code: %d, msg: %s%s", *code, result.Msg, result.ErrMsg)
+		}
+	}
+	return nil
+}